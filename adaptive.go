@@ -0,0 +1,111 @@
+package main
+
+import (
+    "log"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// adaptiveSamples is how many successful dials each calibration of
+// -dial-timeout-adaptive is based on; every further batch recalibrates
+const adaptiveSamples = 50
+
+// adaptiveFactor is the multiple of the median connect time used as the
+// connect timeout
+const adaptiveFactor = 4
+
+// adaptiveTimeout derives the connect timeout from the network instead of
+// -timeout: after each batch of adaptiveSamples successful dials the
+// timeout becomes adaptiveFactor times their median, clamped to
+// [min, max]. Until the first batch completes the fixed timeout is used.
+type adaptiveTimeout struct {
+    min, max time.Duration
+    mu       sync.Mutex
+    rtts     []time.Duration
+    current  atomic.Int64 // nanoseconds, 0 until calibrated
+}
+
+func newAdaptiveTimeout(min, max time.Duration) *adaptiveTimeout {
+    return &adaptiveTimeout{min: min, max: max, rtts: make([]time.Duration, 0, adaptiveSamples)}
+}
+
+// timeout returns the calibrated connect timeout, or fixed before the
+// first calibration. It never exceeds fixed, so a caller's tighter
+// timeout (-connect-timeout, portscan) still holds.
+func (a *adaptiveTimeout) timeout(fixed time.Duration) time.Duration {
+    if d := time.Duration(a.current.Load()); d > 0 {
+        return min(d, fixed)
+    }
+    return fixed
+}
+
+// observe records the connect time of a successful dial
+func (a *adaptiveTimeout) observe(rtt time.Duration) {
+    a.mu.Lock()
+    a.rtts = append(a.rtts, rtt)
+    if len(a.rtts) < adaptiveSamples {
+        a.mu.Unlock()
+        return
+    }
+    sort.Slice(a.rtts, func(i, j int) bool { return a.rtts[i] < a.rtts[j] })
+    median := a.rtts[len(a.rtts)/2]
+    a.rtts = a.rtts[:0]
+    a.mu.Unlock()
+
+    d := min(max(median*adaptiveFactor, a.min), a.max)
+    if old := time.Duration(a.current.Swap(int64(d))); old != d {
+        log.Printf("Connect timeout calibrated to %s (median connect %s over %d dials)", d, median, adaptiveSamples)
+    }
+}
+
+// orderHalfLife is how many hits -adaptive-order counts before halving
+// every protocol's count, so a long run follows a change of range
+const orderHalfLife = 200
+
+// adaptiveOrder reorders the protocol cascade by running per-protocol hit
+// counts (-adaptive-order): the protocol found most often is tried first,
+// so more tasks stop at their first check. Protocols with equal counts
+// keep their -protocol-order position. A nil adaptiveOrder keeps the
+// configured order.
+type adaptiveOrder struct {
+    base    []string
+    mu      sync.Mutex
+    hits    map[string]float64
+    total   int
+    current atomic.Pointer[[]string]
+}
+
+func newAdaptiveOrder(base []string) *adaptiveOrder {
+    o := &adaptiveOrder{base: base, hits: make(map[string]float64)}
+    o.current.Store(&base)
+    return o
+}
+
+// order returns the cascade to use for the next task
+func (o *adaptiveOrder) order(fixed []string) []string {
+    if o == nil {
+        return fixed
+    }
+    return *o.current.Load()
+}
+
+// hit counts a task found as protocol and re-sorts the cascade
+func (o *adaptiveOrder) hit(protocol string) {
+    if o == nil {
+        return
+    }
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.hits[protocol]++
+    if o.total++; o.total >= orderHalfLife {
+        for p := range o.hits {
+            o.hits[p] /= 2
+        }
+        o.total /= 2
+    }
+    order := append([]string(nil), o.base...)
+    sort.SliceStable(order, func(i, j int) bool { return o.hits[order[i]] > o.hits[order[j]] })
+    o.current.Store(&order)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestAdaptiveTimeoutCappedByCaller(t *testing.T) {
+    a := newAdaptiveTimeout(200*time.Millisecond, 10*time.Second)
+    if got := a.timeout(5 * time.Second); got != 5*time.Second {
+        t.Errorf("before calibration timeout = %s, want the caller's 5s", got)
+    }
+    for i := 0; i < adaptiveSamples; i++ {
+        a.observe(time.Second)
+    }
+    calibrated := min(max(time.Second*adaptiveFactor, 200*time.Millisecond), 10*time.Second)
+    if got := a.timeout(30 * time.Second); got != calibrated {
+        t.Errorf("timeout = %s, want the calibrated %s", got, calibrated)
+    }
+    if got := a.timeout(300 * time.Millisecond); got != 300*time.Millisecond {
+        t.Errorf("timeout = %s, want the caller's tighter 300ms", got)
+    }
+}
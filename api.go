@@ -0,0 +1,136 @@
+package main
+
+import (
+    "encoding/json"
+    "net"
+    "net/http"
+    "time"
+)
+
+// apiServer exposes a running scan over HTTP (-api-addr)
+type apiServer struct {
+    stats   *scanStats
+    store   *resultStore
+    refresh chan struct{} // nil unless running in daemon mode
+    token   string        // required on POST requests when non-empty
+}
+
+// startAPI listens on addr and serves the control API in the background
+func startAPI(addr string, api *apiServer) error {
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/status", api.handleStatus)
+    mux.HandleFunc("/proxies", api.handleProxies)
+    mux.HandleFunc("/refresh", api.handleRefresh)
+    go http.Serve(ln, mux)
+    return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(v)
+}
+
+// GET /status: progress of the current pass plus totals
+func (api *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "GET only"})
+        return
+    }
+    done, total := api.stats.progress()
+    status := map[string]interface{}{
+        "tasks_done":  done,
+        "tasks_total": total,
+        "found":       api.store.size(),
+        "elapsed_sec": time.Since(api.stats.start).Seconds(),
+        "daemon":      api.refresh != nil,
+    }
+    if total > 0 {
+        status["progress"] = float64(done) / float64(total)
+    }
+    writeJSON(w, http.StatusOK, status)
+}
+
+// apiProxy is one element of the GET /proxies response
+type apiProxy struct {
+    Address      string    `json:"address"`
+    Protocol     string    `json:"protocol"`
+    LatencyMs    int64     `json:"latency_ms"`
+    FirstSeen    time.Time `json:"first_seen"`
+    LastSeen     time.Time `json:"last_seen"`
+    LastChecked  time.Time `json:"last_checked"`
+    Successes    int       `json:"successes"`
+    Failures     int       `json:"failures"`
+    AuthRequired string    `json:"auth_required,omitempty"`
+    RDNS         string    `json:"rdns,omitempty"`
+    Org          string    `json:"org,omitempty"`
+    BytesPerSec  float64   `json:"bytes_per_sec,omitempty"`
+    Tags         []string  `json:"tags,omitempty"`
+    HTTPMethod   string    `json:"http_method,omitempty"`
+    Software     string    `json:"software,omitempty"`
+    ChainDepth   int       `json:"chain_depth,omitempty"`
+    Capabilities []string  `json:"capabilities,omitempty"`
+    Run          string    `json:"run,omitempty"`
+    RealTarget   string    `json:"real_target,omitempty"`
+    User         string    `json:"user,omitempty"`
+}
+
+// GET /proxies: the current found set
+func (api *apiServer) handleProxies(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "GET only"})
+        return
+    }
+    proxies := []apiProxy{}
+    for _, r := range api.store.snapshot() {
+        proxies = append(proxies, apiProxy{
+            Address:      r.Address,
+            Protocol:     r.Protocol,
+            LatencyMs:    r.Latency.Milliseconds(),
+            FirstSeen:    r.FirstSeen,
+            LastSeen:     r.LastSeen,
+            LastChecked:  r.LastChecked,
+            Successes:    r.Successes,
+            Failures:     r.Failures,
+            AuthRequired: r.AuthRequired,
+            RDNS:         r.RDNS,
+            Org:          r.Org,
+            BytesPerSec:  r.BytesPerSec,
+            Tags:         r.Tags,
+            HTTPMethod:   r.HTTPMethod,
+            Software:     r.Software,
+            ChainDepth:   r.ChainDepth,
+            Capabilities: r.Capabilities,
+            Run:          r.Run,
+            RealTarget:   r.RealTarget,
+            User:         r.User,
+        })
+    }
+    writeJSON(w, http.StatusOK, proxies)
+}
+
+// POST /refresh: start an immediate re-test in daemon mode
+func (api *apiServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST only"})
+        return
+    }
+    if api.token != "" && r.Header.Get("Authorization") != "Bearer "+api.token {
+        writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+        return
+    }
+    if api.refresh == nil {
+        writeJSON(w, http.StatusConflict, map[string]string{"error": "refresh needs -daemon"})
+        return
+    }
+    select {
+    case api.refresh <- struct{}{}:
+        writeJSON(w, http.StatusAccepted, map[string]string{"status": "refresh queued"})
+    default:
+        writeJSON(w, http.StatusAccepted, map[string]string{"status": "refresh already queued"})
+    }
+}
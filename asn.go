@@ -0,0 +1,110 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+)
+
+// asnTable maps IPv4 prefixes to AS numbers, bucketed by prefix length so
+// a lookup is at most 33 map probes (longest prefix first).
+type asnTable struct {
+    byLen [33]map[uint32]uint32
+}
+
+// loadASNTable reads a prefix-to-ASN table, one "CIDR ASN" pair per line
+// (e.g. "1.0.0.0/24 13335" or "1.0.0.0/24 AS13335").
+func loadASNTable(filename string) (*asnTable, error) {
+    lines, err := readLines(filename)
+    if err != nil {
+        return nil, err
+    }
+    t := &asnTable{}
+    for _, line := range lines {
+        fields := strings.Fields(line)
+        if len(fields) < 2 {
+            continue
+        }
+        _, ipnet, err := net.ParseCIDR(fields[0])
+        if err != nil || ipnet.IP.To4() == nil {
+            continue
+        }
+        asn, err := parseASN(fields[1])
+        if err != nil {
+            continue
+        }
+        ones, _ := ipnet.Mask.Size()
+        if t.byLen[ones] == nil {
+            t.byLen[ones] = make(map[uint32]uint32)
+        }
+        t.byLen[ones][binary.BigEndian.Uint32(ipnet.IP.To4())] = asn
+    }
+    return t, nil
+}
+
+// lookup returns the AS number announcing ip, or false if none matches.
+func (t *asnTable) lookup(ip string) (uint32, bool) {
+    parsed := net.ParseIP(ip).To4()
+    if parsed == nil {
+        return 0, false
+    }
+    addr := binary.BigEndian.Uint32(parsed)
+    for ones := 32; ones >= 0; ones-- {
+        if t.byLen[ones] == nil {
+            continue
+        }
+        mask := uint32(0)
+        if ones > 0 {
+            mask = ^uint32(0) << (32 - ones)
+        }
+        if asn, ok := t.byLen[ones][addr&mask]; ok {
+            return asn, true
+        }
+    }
+    return 0, false
+}
+
+// parseASN accepts "13335" or "AS13335".
+func parseASN(s string) (uint32, error) {
+    s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+    n, err := strconv.ParseUint(s, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("invalid ASN %q", s)
+    }
+    return uint32(n), nil
+}
+
+// parseASNList parses a comma-separated list of AS numbers into a set.
+func parseASNList(s string) (map[uint32]bool, error) {
+    set := make(map[uint32]bool)
+    for _, part := range strings.Split(s, ",") {
+        if strings.TrimSpace(part) == "" {
+            continue
+        }
+        asn, err := parseASN(part)
+        if err != nil {
+            return nil, err
+        }
+        set[asn] = true
+    }
+    return set, nil
+}
+
+// filterByASN keeps IPs whose ASN is in include (if non-empty) and not in exclude.
+// IPs with no known ASN are dropped when an include list is given.
+func filterByASN(ips []string, t *asnTable, include, exclude map[uint32]bool) []string {
+    var kept []string
+    for _, ip := range ips {
+        asn, ok := t.lookup(ip)
+        if len(include) > 0 && (!ok || !include[asn]) {
+            continue
+        }
+        if ok && exclude[asn] {
+            continue
+        }
+        kept = append(kept, ip)
+    }
+    return kept
+}
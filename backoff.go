@@ -0,0 +1,59 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "net"
+    "sync/atomic"
+    "time"
+)
+
+// dialBackoff slows dialing down when the network stops answering. Every
+// threshold consecutive timeouts (across all workers) double the delay
+// inserted before each new dial, up to max; any answered dial, even a
+// refused one, clears it.
+type dialBackoff struct {
+    threshold int64
+    max       time.Duration
+    streak    atomic.Int64
+    delay     atomic.Int64 // nanoseconds
+}
+
+const backoffInitialDelay = 100 * time.Millisecond
+
+func newDialBackoff(threshold int, max time.Duration) *dialBackoff {
+    return &dialBackoff{threshold: int64(threshold), max: max}
+}
+
+// wait sleeps for the current backoff delay, if any
+func (b *dialBackoff) wait() {
+    if d := b.delay.Load(); d > 0 {
+        time.Sleep(time.Duration(d))
+    }
+}
+
+// observe updates the timeout streak with the outcome of a dial
+func (b *dialBackoff) observe(err error) {
+    var netErr net.Error
+    if err == nil || !errors.As(err, &netErr) || !netErr.Timeout() {
+        b.streak.Store(0)
+        if b.delay.Swap(0) > 0 {
+            log.Printf("Dials answering again, backoff cleared")
+        }
+        return
+    }
+    n := b.streak.Add(1)
+    if n%b.threshold != 0 {
+        return
+    }
+    d := time.Duration(b.delay.Load()) * 2
+    if d < backoffInitialDelay {
+        d = backoffInitialDelay
+    }
+    if d > b.max {
+        d = b.max
+    }
+    if time.Duration(b.delay.Swap(int64(d))) != d {
+        log.Printf("%d consecutive dial timeouts, backing off %s before each dial", n, d)
+    }
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "time"
+)
+
+// bandwidthTimeout bounds one -bandwidth-test download, handshake included
+const bandwidthTimeout = 10 * time.Second
+
+// measureBandwidth downloads up to maxBytes of target through the proxy p
+// and returns the body throughput in bytes per second. The tunnel is set
+// up with the same handshakes, and credentials, as the checks.
+func (s *Scanner) measureBandwidth(p Proxy, target *testTarget, maxBytes int64) (float64, error) {
+    conn, requestURI, err := s.bandwidthTunnel(p, target)
+    if err != nil {
+        return 0, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(bandwidthTimeout))
+
+    start := time.Now()
+    req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", requestURI, target.hostHeader())
+    if cred, ok := s.Credentials.lookup(p.Address); ok && (p.Protocol == "HTTP" || p.Protocol == "HTTPS-PROXY") {
+        req = withProxyAuth(req, cred)
+    }
+    io.WriteString(conn, req)
+    resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return 0, fmt.Errorf("HTTP %s", resp.Status)
+    }
+    n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxBytes))
+    if n == 0 {
+        return 0, fmt.Errorf("no data: %v", err)
+    }
+    return float64(n) / time.Since(start).Seconds(), nil
+}
+
+// bandwidthTunnel connects through p to target and returns the
+// connection and the request URI to GET over it: the full URL for HTTP
+// proxies, the path once a SOCKS proxy has connected
+func (s *Scanner) bandwidthTunnel(p Proxy, target *testTarget) (net.Conn, string, error) {
+    switch p.Protocol {
+    case "HTTP", "HTTPS-PROXY":
+        conn, err := s.dialTimeout(p.Address, bandwidthTimeout)
+        if err == nil && p.Protocol == "HTTPS-PROXY" {
+            conn, err = s.tlsClient(conn)
+        }
+        return conn, target.URL, err
+    case "SOCKS4":
+        conn, err := s.dialTimeout(p.Address, bandwidthTimeout)
+        if err != nil {
+            return nil, "", err
+        }
+        if err := s.socks4Connect(conn, p.Address, target); err != nil {
+            conn.Close()
+            return nil, "", err
+        }
+        return conn, target.path(), nil
+    case "SOCKS5", "SOCKS5-TLS":
+        conn, err := s.socks5Tunnel(p.Address, p.Protocol == "SOCKS5-TLS", target)
+        return conn, target.path(), err
+    }
+    return nil, "", fmt.Errorf("bandwidth test not supported for %s", p.Protocol)
+}
+
+// socks4Connect asks the SOCKS4 proxy at address, on conn, to connect to
+// target
+func (s *Scanner) socks4Connect(conn net.Conn, address string, target *testTarget) error {
+    req, _, err := s.socks4Request(address, target)
+    if err != nil {
+        return err
+    }
+    conn.SetDeadline(time.Now().Add(bandwidthTimeout))
+    conn.Write(req)
+    reply := make([]byte, 8)
+    if _, err := io.ReadFull(conn, reply); err != nil {
+        return err
+    }
+    if reply[1] != 0x5A {
+        return fmt.Errorf("SOCKS4 connect rejected (0x%02x)", reply[1])
+    }
+    return nil
+}
+
+// socks5Tunnel greets the SOCKS5 proxy at address, authenticating with
+// its credential if it has one, and has it connect to target
+func (s *Scanner) socks5Tunnel(address string, overTLS bool, target *testTarget) (net.Conn, error) {
+    conn, method, err := s.socks5Greet(address, overTLS)
+    if err != nil {
+        return nil, err
+    }
+    if method != 0x00 && method != 0x02 {
+        conn.Close()
+        return nil, fmt.Errorf("SOCKS5 method 0x%02x not supported", method)
+    }
+    conn.SetDeadline(time.Now().Add(bandwidthTimeout))
+    conn.Write(s.socks5Request(0x01, target))
+    // Reply: VER REP RSV ATYP, then a bound address of ATYP's length
+    head := make([]byte, 4)
+    if _, err := io.ReadFull(conn, head); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    if head[1] != 0x00 {
+        conn.Close()
+        return nil, fmt.Errorf("SOCKS5 connect rejected (0x%02x)", head[1])
+    }
+    if _, _, err := readSOCKS5Addr(conn, head[3]); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return conn, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "bufio"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// serveBody answers one HTTP request with n bytes
+func serveBody(n int) func(net.Conn) {
+    return func(conn net.Conn) {
+        if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+            return
+        }
+        conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(n) + "\r\nConnection: close\r\n\r\n"))
+        conn.Write([]byte(strings.Repeat("x", n)))
+    }
+}
+
+func TestMeasureBandwidthSOCKS5Credentials(t *testing.T) {
+    srv := &socks5Server{User: "alice", Pass: "s3cret", Then: serveBody(4096)}
+    address := srv.listen(t)
+    s := testScanner(t)
+    target := s.HTTPTargets.targets[0]
+    p := Proxy{Address: address, Protocol: "SOCKS5"}
+
+    if _, err := s.measureBandwidth(p, target, 1<<20); err == nil {
+        t.Error("bandwidth test passed without the proxy's credentials")
+    }
+    s.Credentials = credentials{address: {"alice", "s3cret"}}
+    bps, err := s.measureBandwidth(p, target, 1<<20)
+    if err != nil {
+        t.Fatalf("bandwidth test with credentials: %v", err)
+    }
+    if bps <= 0 {
+        t.Errorf("throughput = %f, want > 0", bps)
+    }
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "net"
+    "sort"
+    "sync"
+    "time"
+)
+
+// builtinBenchmark is the -benchmark value that runs the workload against
+// an in-process SOCKS5 server, so results depend only on the machine and
+// the build
+const builtinBenchmark = "builtin"
+
+// benchmarkStats collects the outcome of every benchmark check
+type benchmarkStats struct {
+    mu        sync.Mutex
+    latencies []time.Duration // successful checks only
+    errors    int
+}
+
+func (b *benchmarkStats) add(lat []time.Duration, errors int) {
+    b.mu.Lock()
+    b.latencies = append(b.latencies, lat...)
+    b.errors += errors
+    b.mu.Unlock()
+}
+
+// runBenchmark runs the SOCKS5 check against target (or the in-process
+// server for "builtin") from workers goroutines for duration and reports
+// checks/sec, p50/p99 check time and the error rate on w. Every check is
+// a fresh dial, greeting and CONNECT, through the configured dialer, so
+// -tfo, -max-connections and -interface show in the numbers.
+// It returns the process exit code: 1 if no check succeeded.
+func runBenchmark(s *Scanner, target string, workers int, duration time.Duration, w io.Writer) int {
+    c := *s
+    c.Backoff = nil
+    c.Adaptive = nil
+    name := target
+    if target == builtinBenchmark {
+        l, err := listenGrantAll()
+        if err != nil {
+            fmt.Fprintf(w, "Cannot start SOCKS5 server: %v\n", err)
+            return 1
+        }
+        defer l.Close()
+        target, name = l.Addr().String(), "builtin SOCKS5 server"
+    }
+
+    var stats benchmarkStats
+    var wg sync.WaitGroup
+    deadline := time.Now().Add(duration)
+    start := time.Now()
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            var lat []time.Duration
+            errors := 0
+            for time.Now().Before(deadline) {
+                p := Proxy{Address: target}
+                t := time.Now()
+                if err := c.checkSOCKS5(target, &p); err != nil {
+                    errors++
+                    continue
+                }
+                lat = append(lat, time.Since(t))
+            }
+            stats.add(lat, errors)
+        }()
+    }
+    wg.Wait()
+    elapsed := time.Since(start)
+
+    checks := len(stats.latencies) + stats.errors
+    fmt.Fprintf(w, "[*] Benchmark: %d checks in %.1fs with %d workers against %s\n", checks, elapsed.Seconds(), workers, name)
+    if checks == 0 {
+        return 1
+    }
+    fmt.Fprintf(w, "    %.0f checks/s", float64(checks)/elapsed.Seconds())
+    if len(stats.latencies) > 0 {
+        sort.Slice(stats.latencies, func(i, j int) bool { return stats.latencies[i] < stats.latencies[j] })
+        fmt.Fprintf(w, "  p50 %.2fms  p99 %.2fms", ms(percentile(stats.latencies, 50)), ms(percentile(stats.latencies, 99)))
+    }
+    fmt.Fprintf(w, "  errors %.2f%% (%d)\n", 100*float64(stats.errors)/float64(checks), stats.errors)
+    if len(stats.latencies) == 0 {
+        return 1
+    }
+    return 0
+}
+
+// listenGrantAll serves a minimal SOCKS5 server on a random loopback port
+// until the listener is closed: it selects no-auth, reads the request and
+// grants it without connecting anywhere, which is all the check needs
+func listenGrantAll() (net.Listener, error) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return nil, err
+    }
+    go func() {
+        for {
+            conn, err := l.Accept()
+            if err != nil {
+                return
+            }
+            go grantAll(conn)
+        }
+    }()
+    return l, nil
+}
+
+func grantAll(conn net.Conn) {
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(10 * time.Second))
+    head := make([]byte, 2)
+    if _, err := io.ReadFull(conn, head); err != nil || head[0] != 0x05 {
+        return
+    }
+    if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+        return
+    }
+    conn.Write([]byte{0x05, 0x00})
+    req := make([]byte, 4)
+    if _, err := io.ReadFull(conn, req); err != nil {
+        return
+    }
+    if _, _, err := readSOCKS5Addr(conn, req[3]); err != nil {
+        return
+    }
+    conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+}
@@ -0,0 +1,22 @@
+package main
+
+import "syscall"
+
+// bindToDevice reports that -interface can pin sockets to the device
+// itself rather than to one of its addresses
+const bindToDevice = true
+
+// deviceControl returns a Control function that pins dialed sockets to
+// the named interface with SO_BINDTODEVICE, whatever the routing table
+// says. That needs CAP_NET_RAW on kernels before 5.7.
+func deviceControl(device string) func(network, address string, c syscall.RawConn) error {
+    return func(network, address string, c syscall.RawConn) error {
+        var err error
+        if cerr := c.Control(func(fd uintptr) {
+            err = syscall.BindToDevice(int(fd), device)
+        }); cerr != nil {
+            return cerr
+        }
+        return err
+    }
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// bindToDevice reports that -interface falls back to binding the
+// interface's address, as there is no SO_BINDTODEVICE here
+const bindToDevice = false
+
+func deviceControl(device string) func(network, address string, c syscall.RawConn) error {
+    return func(network, address string, c syscall.RawConn) error {
+        return nil
+    }
+}
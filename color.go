@@ -0,0 +1,79 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+// ANSI escape sequences for -color
+const (
+    ansiReset   = "\x1b[0m"
+    ansiGreen   = "\x1b[32m"
+    ansiYellow  = "\x1b[33m"
+    ansiBlue    = "\x1b[34m"
+    ansiMagenta = "\x1b[35m"
+    ansiCyan    = "\x1b[36m"
+)
+
+// colorOn enables the escape sequences of colorize; it is set once from
+// -color before any worker logs
+var colorOn bool
+
+// useColor resolves a -color mode for the terminal f. "auto" colors only
+// a terminal, and not when NO_COLOR is set or TERM is "dumb".
+func useColor(mode string, f *os.File) (bool, error) {
+    switch mode {
+    case "always":
+        return true, nil
+    case "never":
+        return false, nil
+    case "auto":
+        if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+            return false, nil
+        }
+        return isTerminal(f), nil
+    }
+    return false, fmt.Errorf("want auto, always or never")
+}
+
+// isTerminal reports whether f is a character device such as a TTY
+func isTerminal(f *os.File) bool {
+    fi, err := f.Stat()
+    return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in code when color is on
+func colorize(code, s string) string {
+    if !colorOn {
+        return s
+    }
+    return code + s + ansiReset
+}
+
+// protocolColor tells SOCKS and HTTP proxies apart at a glance
+func protocolColor(protocol string) string {
+    switch {
+    case strings.HasPrefix(protocol, "SOCKS"):
+        return ansiCyan
+    case strings.HasPrefix(protocol, "HTTP"):
+        return ansiMagenta
+    }
+    return ansiBlue
+}
+
+// warnWriter colors every log package message, which are the warnings
+// and fatal errors, yellow, leaving the trailing newline outside
+type warnWriter struct {
+    w io.Writer
+}
+
+func (ww warnWriter) Write(p []byte) (int, error) {
+    msg := bytes.TrimSuffix(p, []byte("\n"))
+    if _, err := io.WriteString(ww.w, ansiYellow+string(msg)+ansiReset+string(p[len(msg):])); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
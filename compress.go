@@ -0,0 +1,68 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "io"
+    "os"
+    "sync"
+)
+
+// gzipMagic starts every gzip stream. Inputs are recognised by it rather
+// than by a .gz extension, so fetched lists and renamed files work too.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// maybeGunzip returns r, decompressed if it is gzip
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+    br := bufio.NewReader(r)
+    if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+        return gzip.NewReader(br)
+    }
+    return br, nil
+}
+
+// gunzipBytes is maybeGunzip for a file already read into memory
+func gunzipBytes(data []byte) ([]byte, error) {
+    if !bytes.HasPrefix(data, gzipMagic) {
+        return data, nil
+    }
+    zr, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    return io.ReadAll(zr)
+}
+
+// gzipWriter is the -compress-output stream over the output. Writes and
+// Close are serialised so an interrupt can end the stream cleanly while
+// the writer goroutine is still running; writes after Close fail.
+type gzipWriter struct {
+    mu     sync.Mutex
+    zw     *gzip.Writer
+    closed bool
+}
+
+func newGzipWriter(w io.Writer) *gzipWriter {
+    return &gzipWriter{zw: gzip.NewWriter(w)}
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if g.closed {
+        return 0, os.ErrClosed
+    }
+    return g.zw.Write(b)
+}
+
+// Close writes the gzip trailer; the underlying writer stays open
+func (g *gzipWriter) Close() error {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if g.closed {
+        return nil
+    }
+    g.closed = true
+    return g.zw.Close()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "encoding/base64"
+    "fmt"
+    "io"
+    "net"
+    "strings"
+)
+
+// credential is a username and password for one proxy
+type credential struct {
+    User, Pass string
+}
+
+// credentials maps IP:PORT to the credential the checks use for that
+// proxy (-proxy-credentials-file, -targets-jsonl "user"/"pass"). A nil
+// credentials has no entries, and proxies without one are checked
+// without auth.
+type credentials map[string]credential
+
+// lookup returns the credential for address
+func (c credentials) lookup(address string) (credential, bool) {
+    cred, ok := c[address]
+    return cred, ok
+}
+
+// readCredentialsFile reads a -proxy-credentials-file: "IP:PORT user:pass"
+// or "IP:PORT:user:pass" per line, the two forms providers ship lists in
+func readCredentialsFile(filename string) credentials {
+    lines := readDataFile(filename, "one IP:PORT user:pass line per proxy")
+    creds := make(credentials)
+    var bad invalidLines
+    for _, line := range lines {
+        address, cred, err := parseCredentialLine(line)
+        if err != nil {
+            skipWarn("Skipping invalid credentials line %s: %v", line, err)
+            bad.add(line)
+            continue
+        }
+        creds[address] = cred
+    }
+    if len(creds) == 0 {
+        inputFatal(exitInputInvalid, "No valid credentials in %s: all %d lines are invalid (%s)", filename, len(bad), bad.sample())
+    }
+    return creds
+}
+
+// parseCredentialLine splits one -proxy-credentials-file line
+func parseCredentialLine(line string) (string, credential, error) {
+    address, userpass, ok := strings.Cut(line, " ")
+    if !ok {
+        // IP:PORT:user:pass
+        parts := strings.SplitN(line, ":", 3)
+        if len(parts) < 3 {
+            return "", credential{}, fmt.Errorf("want IP:PORT user:pass")
+        }
+        address, userpass = parts[0]+":"+parts[1], parts[2]
+    }
+    user, pass, ok := strings.Cut(strings.TrimSpace(userpass), ":")
+    if !ok || user == "" {
+        return "", credential{}, fmt.Errorf("want user:pass")
+    }
+    if len(user) > 255 || len(pass) > 255 {
+        return "", credential{}, fmt.Errorf("user and password must be at most 255 bytes")
+    }
+    host, port, err := net.SplitHostPort(address)
+    if err != nil || net.ParseIP(host) == nil {
+        return "", credential{}, fmt.Errorf("bad address %q", address)
+    }
+    return net.JoinHostPort(host, port), credential{user, pass}, nil
+}
+
+// withProxyAuth adds a Basic Proxy-Authorization header to an HTTP proxy
+// request built by httpRequest
+func withProxyAuth(req string, cred credential) string {
+    line, rest, _ := strings.Cut(req, "\r\n")
+    token := base64.StdEncoding.EncodeToString([]byte(cred.User + ":" + cred.Pass))
+    return line + "\r\nProxy-Authorization: Basic " + token + "\r\n" + rest
+}
+
+// socks5Auth runs the RFC 1929 username/password subnegotiation after
+// the server selected method 0x02
+func socks5Auth(conn net.Conn, cred credential) error {
+    req := []byte{0x01, byte(len(cred.User))}
+    req = append(req, cred.User...)
+    req = append(req, byte(len(cred.Pass)))
+    req = append(req, cred.Pass...)
+    conn.Write(req)
+    resp := make([]byte, 2)
+    if _, err := io.ReadFull(conn, resp); err != nil {
+        return fmt.Errorf("read auth reply: %w", err)
+    }
+    if resp[1] != 0x00 {
+        return fmt.Errorf("credentials rejected (0x%02x)", resp[1])
+    }
+    return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestReadCredentialsFileKeepsHash(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "creds.txt")
+    data := "# provider export\n" +
+        "  # indented comment\n" +
+        "192.0.2.1:1080 alice:p#ss\n" +
+        "192.0.2.2:8080:bob:#secret# \n" +
+        "\n"
+    if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+        t.Fatal(err)
+    }
+    got := readCredentialsFile(file)
+    want := credentials{
+        "192.0.2.1:1080": {"alice", "p#ss"},
+        "192.0.2.2:8080": {"bob", "#secret#"},
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("readCredentialsFile = %v, want %v", got, want)
+    }
+}
+
+func TestTrimLine(t *testing.T) {
+    tests := []struct{ line, want string }{
+        {"  a#b  ", "a#b"},
+        {"# comment", ""},
+        {"   # comment", ""},
+        {"", ""},
+        {`{"tags":["#us"]}`, `{"tags":["#us"]}`},
+    }
+    for _, tc := range tests {
+        if got := trimLine(tc.line); got != tc.want {
+            t.Errorf("trimLine(%q) = %q, want %q", tc.line, got, tc.want)
+        }
+    }
+}
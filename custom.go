@@ -0,0 +1,28 @@
+package main
+
+import (
+    "context"
+    "os/exec"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// runCustomCheck executes the -custom-check program as "prog IP PORT TIMEOUT"
+// and treats exit status 0 as a working proxy. The first line of its stdout,
+// if any, is used as the protocol label.
+func (s *Scanner) runCustomCheck(ip string, port int) (string, bool) {
+    timeout := time.Duration(s.Timeout) * time.Second
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    cmd := exec.CommandContext(ctx, s.CustomCheck, ip, strconv.Itoa(port), strconv.Itoa(s.Timeout))
+    out, err := cmd.Output()
+    if err != nil {
+        return "", false
+    }
+    label := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+    if label == "" {
+        label = "CUSTOM"
+    }
+    return label, true
+}
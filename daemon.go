@@ -0,0 +1,93 @@
+package main
+
+import (
+    "log"
+    "sync"
+    "time"
+)
+
+// recheck re-tests the stored records with retest, from up to workers
+// goroutines, merges the passes into the store and evicts those that
+// failed evictAfter times in a row. With retryDeadAfter, a failing address
+// is skipped until that long after its last check. It returns the number
+// of evicted records.
+func recheck(store *resultStore, retest func(r Record) (Proxy, bool), workers, evictAfter int, retryDeadAfter time.Duration, logLevel string) int {
+    jobs := make(chan Record)
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for r := range jobs {
+                if p, ok := retest(r); ok {
+                    store.refresh(p)
+                    continue
+                }
+                store.fail(r.Address)
+                logPrint("debug", logLevel, "[-] %s failed re-test\n", r.Address)
+            }
+        }()
+    }
+    skipped := 0
+    for _, r := range store.snapshot() {
+        if retryDeadAfter > 0 && r.ConsecutiveFailures > 0 && time.Since(r.LastChecked) < retryDeadAfter {
+            skipped++
+            continue
+        }
+        jobs <- r
+    }
+    close(jobs)
+    if skipped > 0 {
+        logPrint("info", logLevel, "[*] Refresh: skipped %d failing proxies until -retry-dead-after\n", skipped)
+    }
+    wg.Wait()
+    return store.evict(evictAfter)
+}
+
+// runDaemon scans once, then on every refresh tick (or request on
+// refreshNow) re-tests the stored proxies with retest, rescans for new ones, and
+// rewrites outPath from the store (gzipped if compress is set), followed
+// by its -output-hash sidecar if hash is set. It returns only when
+// aborted reports, after a pass, that -max-errors has tripped.
+func runDaemon(retest func(r Record) (Proxy, bool), scan func(emit func(p Proxy)), aborted func() bool, store *resultStore, refreshNow chan struct{}, outPath string, compress, hash bool, refreshMinutes, evictAfter int, retryDeadAfter time.Duration, workers int, logLevel string) {
+    save := func() {
+        if err := store.writeFile(outPath, compress); err != nil {
+            log.Printf("Cannot write %s: %v", outPath, err)
+            return
+        }
+        if !hash {
+            return
+        }
+        sum, err := writeChecksum(outPath)
+        if err != nil {
+            log.Printf("Cannot write %s.sha256: %v", outPath, err)
+            return
+        }
+        logPrint("info", logLevel, "[*] Wrote %s, sha256 %s\n", outPath, sum)
+    }
+    add := func(p Proxy) { store.upsert(p) }
+
+    scan(add)
+    save()
+    if aborted() {
+        return
+    }
+
+    ticker := time.NewTicker(time.Duration(refreshMinutes) * time.Minute)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+        case <-refreshNow:
+        }
+        logPrint("info", logLevel, "[*] Refresh: re-testing %d proxies\n", store.size())
+        evicted := recheck(store, retest, workers, evictAfter, retryDeadAfter, logLevel)
+        logPrint("info", logLevel, "[*] Refresh: evicted %d proxies\n", evicted)
+        save()
+        scan(add)
+        save()
+        if aborted() {
+            return
+        }
+    }
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestRecheckMergesIntoRecords(t *testing.T) {
+    store := newResultStore()
+    store.upsert(Proxy{Address: "192.0.2.1:1080", Protocol: "SOCKS5", Latency: time.Second, Tags: []string{"dc"}, Run: "r1", Org: "Example", BytesPerSec: 1e6, User: "alice"})
+    store.upsert(Proxy{Address: "192.0.2.2:8080", Protocol: "HTTP"})
+
+    var seen []Record
+    retest := func(r Record) (Proxy, bool) {
+        seen = append(seen, r)
+        if r.Address == "192.0.2.2:8080" {
+            return Proxy{}, false
+        }
+        return Proxy{Address: r.Address, Protocol: "SOCKS5", Latency: 50 * time.Millisecond, User: "alice"}, true
+    }
+    if n := recheck(store, retest, 1, 1, 0, "error"); n != 1 {
+        t.Errorf("evicted %d records, want 1", n)
+    }
+    if len(seen) != 2 || seen[0].Tags == nil {
+        t.Errorf("retest saw %v, want both records with their tags", seen)
+    }
+    records := store.snapshot()
+    if len(records) != 1 {
+        t.Fatalf("store has %d records, want 1", len(records))
+    }
+    p := records[0].Proxy
+    if p.Latency != 50*time.Millisecond {
+        t.Errorf("latency = %s, want the re-test's 50ms", p.Latency)
+    }
+    if len(p.Tags) != 1 || p.Run != "r1" || p.Org != "Example" || p.BytesPerSec != 1e6 || p.User != "alice" {
+        t.Errorf("re-test dropped metadata of the original find: %+v", p)
+    }
+    if records[0].Successes != 2 {
+        t.Errorf("successes = %d, want 2", records[0].Successes)
+    }
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "sync"
+    "sync/atomic"
+    "syscall"
+    "time"
+
+    "golang.org/x/net/proxy"
+)
+
+// Dialer opens the connections the checks talk over. The Scanner's default
+// dials directly; -through swaps in an upstream proxy, and tests can
+// inject one that returns scripted connections.
+type Dialer interface {
+    DialTimeout(network, address string, timeout time.Duration) (net.Conn, error)
+    DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// netDialer dials directly with the net package, from local if set
+// (-dial-from), from a rotating source port if ports is set
+// (-source-ports), through device if set (-interface) and with TCP Fast
+// Open if tfo is set (-tfo)
+type netDialer struct {
+    local  net.Addr
+    ports  *sourcePorts
+    device string
+    tfo    bool
+}
+
+// dialer returns the net.Dialer for n
+func (n netDialer) dialer(timeout time.Duration) *net.Dialer {
+    d := &net.Dialer{Timeout: timeout, LocalAddr: n.local}
+    if n.tfo || n.device != "" {
+        d.Control = n.control
+    }
+    return d
+}
+
+// control applies -tfo and -interface to a socket before it connects
+func (n netDialer) control(network, address string, c syscall.RawConn) error {
+    if n.tfo {
+        if err := tfoControl(network, address, c); err != nil {
+            return err
+        }
+    }
+    if n.device != "" {
+        return deviceControl(n.device)(network, address, c)
+    }
+    return nil
+}
+
+// pinInterface sends n's dials out of the named -interface: with
+// SO_BINDTODEVICE where available, checked now by binding a throwaway
+// socket, otherwise from the interface's first address of family (4, 6
+// or 0 for either) unless -dial-from already chose one
+func (n *netDialer) pinInterface(name string, family int) error {
+    ifi, err := net.InterfaceByName(name)
+    if err != nil {
+        return err
+    }
+    if bindToDevice {
+        lc := net.ListenConfig{Control: deviceControl(ifi.Name)}
+        l, err := lc.Listen(context.Background(), "tcp", ":0")
+        if err != nil {
+            return err
+        }
+        l.Close()
+        n.device = ifi.Name
+        return nil
+    }
+    if n.local != nil {
+        return nil
+    }
+    addrs, err := ifi.Addrs()
+    if err != nil {
+        return err
+    }
+    for _, a := range addrs {
+        ipnet, ok := a.(*net.IPNet)
+        if ok && !ipnet.IP.IsLinkLocalUnicast() && (family == 0 || ipFamily(ipnet.IP) == family) {
+            n.local = &net.TCPAddr{IP: ipnet.IP}
+            return nil
+        }
+    }
+    return fmt.Errorf("no usable address on %s", ifi.Name)
+}
+
+// handshakeKey marks a dial context whose connect must complete the TCP
+// handshake before returning. With -tfo, connect returns at once and the
+// SYN waits for the first write, so a dial that is itself the answer
+// (isOpen) would report every port open in no time.
+type handshakeKey struct{}
+
+// withHandshake returns ctx with TCP Fast Open disabled for its dials
+func withHandshake(ctx context.Context) context.Context {
+    return context.WithValue(ctx, handshakeKey{}, true)
+}
+
+func (n netDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+    return n.dialPorts(context.Background(), timeout, network, address)
+}
+
+func (n netDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+    return n.dialPorts(ctx, 0, network, address)
+}
+
+// dialPorts dials from the next -source-ports port, moving on to the
+// following one while the port is still in use (e.g. in TIME_WAIT), at
+// most once around the range. Without -source-ports the OS picks.
+func (n netDialer) dialPorts(ctx context.Context, timeout time.Duration, network, address string) (net.Conn, error) {
+    if ctx.Value(handshakeKey{}) != nil {
+        n.tfo = false
+    }
+    d := n.dialer(timeout)
+    if n.ports == nil {
+        return d.DialContext(ctx, network, address)
+    }
+    var ip net.IP
+    if local, ok := n.local.(*net.TCPAddr); ok {
+        ip = local.IP
+    }
+    var err error
+    for i := 0; i < n.ports.size(); i++ {
+        d.LocalAddr = &net.TCPAddr{IP: ip, Port: n.ports.next()}
+        var conn net.Conn
+        if conn, err = d.DialContext(ctx, network, address); !errors.Is(err, syscall.EADDRINUSE) {
+            return conn, err
+        }
+    }
+    return nil, fmt.Errorf("every -source-ports port is in use: %w", err)
+}
+
+// sourcePorts hands out the ports of a -source-ports range round-robin
+type sourcePorts struct {
+    first, last int
+    n           atomic.Uint64
+}
+
+// parseSourcePorts parses a -source-ports start-end range
+func parseSourcePorts(s string) (*sourcePorts, error) {
+    first, last, err := parsePortRange(s)
+    if err != nil {
+        return nil, err
+    }
+    if first < 1 || last > 65535 || first > last {
+        return nil, fmt.Errorf("want start-end with 1 <= start <= end <= 65535")
+    }
+    return &sourcePorts{first: first, last: last}, nil
+}
+
+func (sp *sourcePorts) size() int {
+    return sp.last - sp.first + 1
+}
+
+// next returns the port after the one handed out last
+func (sp *sourcePorts) next() int {
+    return sp.first + int((sp.n.Add(1)-1)%uint64(sp.size()))
+}
+
+// localAddr parses a -dial-from IP and checks that it can be bound
+func localAddr(s string) (net.Addr, error) {
+    ip := net.ParseIP(s)
+    if ip == nil {
+        return nil, fmt.Errorf("not an IP address")
+    }
+    addr := &net.TCPAddr{IP: ip}
+    l, err := net.ListenTCP("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    l.Close()
+    return addr, nil
+}
+
+// upstreamDialer tunnels every connection through a proxy.Dialer
+type upstreamDialer struct {
+    d proxy.Dialer
+}
+
+func (u upstreamDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    return u.DialContext(ctx, network, address)
+}
+
+func (u upstreamDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+    if cd, ok := u.d.(proxy.ContextDialer); ok {
+        return cd.DialContext(ctx, network, address)
+    }
+    return u.d.Dial(network, address)
+}
+
+// limitDialer caps the connections open at once across all workers
+// (-max-connections). A slot is taken right before each dial and given
+// back when the check closes the connection, or at once if the dial fails.
+type limitDialer struct {
+    Dialer
+    slots chan struct{}
+}
+
+func newLimitDialer(d Dialer, n int) limitDialer {
+    return limitDialer{Dialer: d, slots: make(chan struct{}, n)}
+}
+
+func (l limitDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+    l.slots <- struct{}{}
+    return l.hold(l.Dialer.DialTimeout(network, address, timeout))
+}
+
+func (l limitDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+    select {
+    case l.slots <- struct{}{}:
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+    return l.hold(l.Dialer.DialContext(ctx, network, address))
+}
+
+// hold ties the slot taken for a dial to the resulting connection
+func (l limitDialer) hold(conn net.Conn, err error) (net.Conn, error) {
+    if err != nil {
+        <-l.slots
+        return nil, err
+    }
+    return &slotConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+// slotConn gives its -max-connections slot back on the first Close
+type slotConn struct {
+    net.Conn
+    once    sync.Once
+    release func()
+}
+
+func (c *slotConn) Close() error {
+    err := c.Conn.Close()
+    c.once.Do(c.release)
+    return err
+}
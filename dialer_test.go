@@ -0,0 +1,87 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "testing"
+    "time"
+)
+
+// fastOpenDialer mimics a TCP Fast Open connect to a closed port: a plain
+// dial "succeeds" at once, while a dial that must complete the handshake
+// is refused
+type fastOpenDialer struct{}
+
+func (fastOpenDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+    c, _ := net.Pipe()
+    return c, nil
+}
+
+func (fastOpenDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+    if ctx.Value(handshakeKey{}) == nil {
+        c, _ := net.Pipe()
+        return c, nil
+    }
+    return nil, errors.New("connection refused")
+}
+
+func TestIsOpenWithTFO(t *testing.T) {
+    s := &Scanner{Dialer: fastOpenDialer{}, TFO: true, FDs: &fdGuard{}}
+    if s.isOpen("192.0.2.1:1080", time.Second) {
+        t.Error("isOpen took a Fast Open connect as an open port")
+    }
+}
+
+func TestIsOpenOnLoopback(t *testing.T) {
+    s := &Scanner{Dialer: netDialer{tfo: true}, TFO: true, FDs: &fdGuard{}}
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    address := l.Addr().String()
+    if !s.isOpen(address, time.Second) {
+        t.Error("isOpen reported a listening port closed")
+    }
+    l.Close()
+    if s.isOpen(address, time.Second) {
+        t.Error("isOpen reported a closed port open")
+    }
+}
+
+func TestDetectThroughInjectedDialer(t *testing.T) {
+    requests := make(chan string, 4)
+    d := &scriptDialer{serve: func(conn net.Conn) {
+        req, err := http.ReadRequest(bufio.NewReader(conn))
+        if err != nil {
+            return
+        }
+        requests <- req.RequestURI
+        conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok"))
+    }}
+    s := testScanner(t)
+    limit := newLimitDialer(d, 1)
+    s.Dialer = limit
+    s = s.withStatus(&workerStatus{})
+
+    p, attempts, ok := s.detect("192.0.2.1:3128")
+    if !ok || p.Protocol != "HTTP" {
+        t.Fatalf("detect = %s %v, want HTTP (attempts %v)", p.Protocol, ok, attempts)
+    }
+    if uri := <-requests; uri != "http://example.com/" {
+        t.Errorf("proxy got request for %q, want the absolute test URL", uri)
+    }
+    if len(d.dials) == 0 {
+        t.Fatal("no dial went through the injected Dialer")
+    }
+    for _, address := range d.dials {
+        if address != "192.0.2.1:3128" {
+            t.Errorf("dialed %s, want only the proxy", address)
+        }
+    }
+    if n := len(limit.slots); n != 0 {
+        t.Errorf("%d -max-connections slots still held after detect", n)
+    }
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "sync"
+    "time"
+)
+
+// dnsCache resolves test-target hostnames once and reuses the answer
+// until it is older than ttl. With a family (4 or 6, from -ipv4-only or
+// -ipv6-only) only addresses of that family are kept.
+type dnsCache struct {
+    ttl     time.Duration
+    family  int
+    mu      sync.Mutex
+    entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+    ips     []net.IP
+    expires time.Time
+}
+
+func newDNSCache(ttl time.Duration, family int) *dnsCache {
+    return &dnsCache{ttl: ttl, family: family, entries: make(map[string]dnsEntry)}
+}
+
+// lookup returns the cached addresses for host, resolving on a miss or
+// once the entry has expired. IP literals are returned as-is.
+func (c *dnsCache) lookup(host string) ([]net.IP, error) {
+    if ip := net.ParseIP(host); ip != nil {
+        return []net.IP{ip}, nil
+    }
+    c.mu.Lock()
+    e, ok := c.entries[host]
+    c.mu.Unlock()
+    if ok && time.Now().Before(e.expires) {
+        return e.ips, nil
+    }
+    ips, err := net.LookupIP(host)
+    if err == nil && c.family != 0 {
+        kept := ips[:0]
+        for _, ip := range ips {
+            if ipFamily(ip) == c.family {
+                kept = append(kept, ip)
+            }
+        }
+        if ips = kept; len(ips) == 0 {
+            err = fmt.Errorf("no IPv%d address for %s", c.family, host)
+        }
+    }
+    if err != nil {
+        if ok {
+            // keep serving the stale answer rather than failing every check
+            return e.ips, nil
+        }
+        return nil, err
+    }
+    c.mu.Lock()
+    c.entries[host] = dnsEntry{ips: ips, expires: time.Now().Add(c.ttl)}
+    c.mu.Unlock()
+    return ips, nil
+}
+
+// lookupIPv4 returns the first IPv4 address for host
+func (c *dnsCache) lookupIPv4(host string) (net.IP, error) {
+    ips, err := c.lookup(host)
+    if err != nil {
+        return nil, err
+    }
+    for _, ip := range ips {
+        if v4 := ip.To4(); v4 != nil {
+            return v4, nil
+        }
+    }
+    return nil, fmt.Errorf("no IPv4 address for %s", host)
+}
+
+// warm resolves every target host up front
+func (c *dnsCache) warm(pools ...*targetPool) error {
+    for _, pool := range pools {
+        for _, t := range pool.targets {
+            if _, err := c.lookup(t.Host); err != nil {
+                return fmt.Errorf("resolving %s: %v", t.Host, err)
+            }
+        }
+    }
+    return nil
+}
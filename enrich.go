@@ -0,0 +1,132 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+// whoisServer answers " -v IP" queries with a pipe-separated line ending
+// in the network owner's name
+const whoisServer = "whois.cymru.com:43"
+
+// enrichOptions selects the -enrich lookups and the -bandwidth-test
+type enrichOptions struct {
+    RDNS  bool
+    WHOIS bool
+
+    Bandwidth      *testTarget // object to download, nil disables
+    BandwidthBytes int64       // download cap
+}
+
+// parseEnrich parses a comma-separated -enrich list ("rdns,whois")
+func parseEnrich(s string) (enrichOptions, error) {
+    var opts enrichOptions
+    for _, part := range strings.Split(s, ",") {
+        switch strings.ToLower(strings.TrimSpace(part)) {
+        case "":
+        case "rdns":
+            opts.RDNS = true
+        case "whois":
+            opts.WHOIS = true
+        default:
+            return opts, fmt.Errorf("unknown enrichment %q (want rdns or whois)", part)
+        }
+    }
+    return opts, nil
+}
+
+func (o enrichOptions) enabled() bool {
+    return o.RDNS || o.WHOIS || o.Bandwidth != nil
+}
+
+// enricher annotates found proxies in its own goroutine pool so lookups
+// and bandwidth tests never hold up the scan workers. Enriched proxies are
+// passed to next; failed or timed-out lookups just leave the fields empty.
+type enricher struct {
+    s       *Scanner
+    opts    enrichOptions
+    timeout time.Duration
+    next    func(p Proxy)
+    jobs    chan Proxy
+    wg      sync.WaitGroup
+}
+
+func newEnricher(s *Scanner, opts enrichOptions, workers int, timeout time.Duration, next func(p Proxy)) *enricher {
+    e := &enricher{s: s, opts: opts, timeout: timeout, next: next, jobs: make(chan Proxy, workers*2)}
+    for i := 0; i < workers; i++ {
+        e.wg.Add(1)
+        go func() {
+            defer e.wg.Done()
+            for p := range e.jobs {
+                e.enrich(&p)
+                e.next(p)
+            }
+        }()
+    }
+    return e
+}
+
+// submit queues p for enrichment
+func (e *enricher) submit(p Proxy) {
+    e.jobs <- p
+}
+
+// close waits until every submitted proxy has been passed on
+func (e *enricher) close() {
+    close(e.jobs)
+    e.wg.Wait()
+}
+
+func (e *enricher) enrich(p *Proxy) {
+    host, _, err := net.SplitHostPort(p.Address)
+    if err != nil {
+        return
+    }
+    if e.opts.RDNS {
+        ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+        names, err := net.DefaultResolver.LookupAddr(ctx, host)
+        cancel()
+        if err == nil && len(names) > 0 {
+            p.RDNS = strings.TrimSuffix(names[0], ".")
+        }
+    }
+    if e.opts.WHOIS {
+        p.Org = whoisOrg(host, e.timeout)
+    }
+    if e.opts.Bandwidth != nil && p.AuthRequired == "" {
+        if bps, err := e.s.measureBandwidth(*p, e.opts.Bandwidth, e.opts.BandwidthBytes); err == nil {
+            p.BytesPerSec = bps
+        }
+    }
+}
+
+// whoisOrg returns the owner of the network announcing ip, or "" when the
+// lookup fails
+func whoisOrg(ip string, timeout time.Duration) string {
+    conn, err := net.DialTimeout("tcp", whoisServer, timeout)
+    if err != nil {
+        return ""
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(timeout))
+    if _, err := fmt.Fprintf(conn, " -v %s\r\n", ip); err != nil {
+        return ""
+    }
+    // The first line is a column header; the answer is the last field of
+    // the line after it
+    var org string
+    sc := bufio.NewScanner(conn)
+    for sc.Scan() {
+        fields := strings.Split(sc.Text(), "|")
+        if len(fields) < 2 || strings.HasPrefix(sc.Text(), "AS ") {
+            continue
+        }
+        org = strings.TrimSpace(fields[len(fields)-1])
+    }
+    return org
+}
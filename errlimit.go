@@ -0,0 +1,54 @@
+package main
+
+import (
+    "log"
+    "sync"
+    "sync/atomic"
+)
+
+// exitNoConnectivity is the exit code when -max-errors finds that the
+// scanner itself has lost its network
+const exitNoConnectivity = 6
+
+// errorLimit aborts the run once max targets in a row could not be
+// reached and the control host cannot be reached either. A dead range
+// alone resets the count, since the control host still answers. Workers
+// stop taking tasks once it trips, and main exits with
+// exitNoConnectivity after the usual shutdown, so buffered output, the
+// gzip trailer and -seen-db are still written.
+type errorLimit struct {
+    max       int64
+    run       atomic.Int64 // consecutive unreachable targets
+    probing   sync.Mutex
+    reachable func() bool // probes the control host
+    tripped   atomic.Bool
+}
+
+// aborted reports whether the limit has tripped
+func (l *errorLimit) aborted() bool {
+    return l != nil && l.tripped.Load()
+}
+
+// observe counts one task outcome, probing the control host when the run
+// of unreachable targets hits the limit
+func (l *errorLimit) observe(unreachable bool) {
+    if l == nil {
+        return
+    }
+    if !unreachable {
+        l.run.Store(0)
+        return
+    }
+    if l.run.Add(1) < l.max || l.tripped.Load() || !l.probing.TryLock() {
+        return
+    }
+    defer l.probing.Unlock()
+    if n := l.run.Load(); n >= l.max {
+        if l.reachable() {
+            l.run.Store(0)
+            return
+        }
+        log.Printf("Aborting: %d targets in a row were unreachable and so is the control host; the scanner's network looks down", n)
+        l.tripped.Store(true)
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "net"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// eventSchemaVersion is the schema_version of -output-append-jsonl
+// records. Bump it whenever proxyEvent gains, loses or redefines a field.
+const eventSchemaVersion = 2
+
+// proxyEvent is one -output-append-jsonl record: a proxy found at ts
+type proxyEvent struct {
+    SchemaVersion int      `json:"schema_version"`
+    TS            string   `json:"ts"` // RFC 3339, UTC
+    IP            string   `json:"ip"`
+    Port          int      `json:"port"`
+    Protocol      string   `json:"protocol"`
+    LatencyMs     int64    `json:"latency_ms"`
+    AuthRequired  string   `json:"auth_required,omitempty"`
+    Software      string   `json:"software,omitempty"`
+    RDNS          string   `json:"rdns,omitempty"`
+    Org           string   `json:"org,omitempty"`
+    BytesPerSec   float64  `json:"bytes_per_sec,omitempty"`
+    Tags          []string `json:"tags,omitempty"`
+    Run           string   `json:"run,omitempty"` // -tag or the generated run ID, since version 2
+}
+
+// newRunID returns the ID of a run started at t when no -tag is given,
+// e.g. 20261014T153044Z-3f9a1c
+func newRunID(t time.Time) string {
+    b := make([]byte, 3)
+    rand.Read(b)
+    return t.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// eventLog appends one JSON line per found proxy. Each record is a single
+// write to a file opened with O_APPEND, so lines stay whole across runs
+// and concurrent writers.
+type eventLog struct {
+    mu sync.Mutex
+    f  *os.File
+}
+
+func openEventLog(path string) (*eventLog, error) {
+    f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &eventLog{f: f}, nil
+}
+
+// write appends the event for p
+func (l *eventLog) write(p Proxy) error {
+    host, portStr, err := net.SplitHostPort(p.Address)
+    if err != nil {
+        return err
+    }
+    port, _ := strconv.Atoi(portStr)
+    line, err := json.Marshal(proxyEvent{
+        SchemaVersion: eventSchemaVersion,
+        TS:            time.Now().UTC().Format(time.RFC3339Nano),
+        IP:            host,
+        Port:          port,
+        Protocol:      p.Protocol,
+        LatencyMs:     p.Latency.Milliseconds(),
+        AuthRequired:  p.AuthRequired,
+        Software:      p.Software,
+        RDNS:          p.RDNS,
+        Org:           p.Org,
+        BytesPerSec:   p.BytesPerSec,
+        Tags:          p.Tags,
+        Run:           p.Run,
+    })
+    if err != nil {
+        return err
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    _, err = l.f.Write(append(line, '\n'))
+    return err
+}
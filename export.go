@@ -0,0 +1,129 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "text/template"
+)
+
+// exporter renders the working proxies for one -export-format target
+type exporter struct {
+    file string // written in the output directory
+    tmpl *template.Template
+}
+
+// exporters are the -export-format targets. Each template gets an
+// exportSet; squid can only forward to HTTP parents, so it uses the HTTP
+// group alone.
+var exporters = map[string]exporter{
+    "squid": {"proxies.squid.conf", template.Must(template.New("squid").Parse(
+        `# Generated by proxyscanner: working HTTP proxies as round-robin parents
+{{range .HTTP}}cache_peer {{.Host}} parent {{.Port}} 0 no-query no-digest round-robin name={{.Name}}
+{{end}}never_direct allow all
+`))},
+    "haproxy": {"proxies.haproxy.cfg", template.Must(template.New("haproxy").Parse(
+        `# Generated by proxyscanner: one round-robin TCP backend per protocol
+{{range .Groups}}
+backend proxies_{{.Scheme}}
+    mode tcp
+    balance roundrobin
+{{range .Proxies}}    server {{.Name}} {{.Address}} check
+{{end}}{{end}}`))},
+    "yaml": {"proxies.yaml", template.Must(template.New("yaml").Parse(
+        `# Generated by proxyscanner: proxy URLs grouped by protocol
+proxies:
+{{range .Groups}}  {{.Scheme}}:
+{{range .Proxies}}    - {{.URL}}
+{{end}}{{end}}`))},
+}
+
+// exportProxy is one proxy as the export templates see it
+type exportProxy struct {
+    Name    string // unique, e.g. ps-socks5-3
+    Address string
+    Host    string
+    Port    string
+    URL     string
+}
+
+// exportGroup is the proxies of one protocol
+type exportGroup struct {
+    Scheme  string // proxyURL scheme, e.g. socks5
+    Proxies []exportProxy
+}
+
+// exportSet is the data passed to every export template
+type exportSet struct {
+    Groups []exportGroup // sorted by scheme
+    HTTP   []exportProxy
+}
+
+// parseExportFormats parses a comma-separated -export-format list
+func parseExportFormats(s string) ([]string, error) {
+    var formats []string
+    for _, name := range strings.Split(s, ",") {
+        name = strings.ToLower(strings.TrimSpace(name))
+        if name == "" {
+            continue
+        }
+        if _, ok := exporters[name]; !ok {
+            return nil, fmt.Errorf("unknown format %q (want squid, haproxy or yaml)", name)
+        }
+        formats = append(formats, name)
+    }
+    return formats, nil
+}
+
+// newExportSet groups the records that passed their last check and can be
+// used without credentials
+func newExportSet(records []Record) exportSet {
+    byScheme := make(map[string][]exportProxy)
+    for _, r := range records {
+        scheme, ok := proxyScheme[r.Protocol]
+        if !ok || r.AuthRequired != "" || r.ConsecutiveFailures > 0 {
+            continue
+        }
+        host, port, err := net.SplitHostPort(r.Address)
+        if err != nil {
+            continue
+        }
+        name := fmt.Sprintf("ps-%s-%d", scheme, len(byScheme[scheme])+1)
+        byScheme[scheme] = append(byScheme[scheme], exportProxy{name, r.Address, host, port, proxyURL(r.Proxy)})
+    }
+    var set exportSet
+    for scheme, proxies := range byScheme {
+        set.Groups = append(set.Groups, exportGroup{scheme, proxies})
+    }
+    sort.Slice(set.Groups, func(i, j int) bool { return set.Groups[i].Scheme < set.Groups[j].Scheme })
+    set.HTTP = byScheme["http"]
+    return set
+}
+
+// writeExports atomically writes each format's file into dir
+func writeExports(formats []string, dir string, records []Record) error {
+    set := newExportSet(records)
+    for _, name := range formats {
+        e := exporters[name]
+        path := filepath.Join(dir, e.file)
+        tmp := path + ".tmp"
+        f, err := os.Create(tmp)
+        if err != nil {
+            return err
+        }
+        if err := e.tmpl.Execute(f, set); err != nil {
+            f.Close()
+            return fmt.Errorf("%s: %w", name, err)
+        }
+        if err := f.Close(); err != nil {
+            return err
+        }
+        if err := os.Rename(tmp, path); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "log"
+    "os"
+)
+
+// failureEntry is one line for the failure log, or, with synced set, a
+// request to flush and signal it
+type failureEntry struct {
+    line   string
+    synced chan struct{}
+}
+
+// failureLog writes "IP:PORT - reason" for every failed task to the
+// -record-failures file from its own goroutine, so workers never wait on
+// the disk
+type failureLog struct {
+    entries chan failureEntry
+}
+
+// openFailureLog truncates path and starts the writer. buffer is how many
+// lines may queue up before workers block.
+func openFailureLog(path string, buffer int) (*failureLog, error) {
+    f, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+    l := &failureLog{entries: make(chan failureEntry, buffer)}
+    go func() {
+        w := bufio.NewWriter(f)
+        for e := range l.entries {
+            if e.synced == nil {
+                w.WriteString(e.line + "\n")
+                if len(l.entries) > 0 {
+                    continue
+                }
+            }
+            if err := w.Flush(); err != nil {
+                log.Printf("Cannot write -record-failures: %v", err)
+            }
+            if e.synced != nil {
+                close(e.synced)
+            }
+        }
+    }()
+    return l, nil
+}
+
+// record queues the failure of address
+func (l *failureLog) record(address, reason string) {
+    if l == nil {
+        return
+    }
+    l.entries <- failureEntry{line: address + " - " + reason}
+}
+
+// sync waits until everything recorded so far is on disk
+func (l *failureLog) sync() {
+    if l == nil {
+        return
+    }
+    done := make(chan struct{})
+    l.entries <- failureEntry{synced: done}
+    <-done
+}
+
+// failureReason describes why a task failed for -record-failures: the
+// dial error class (refused, timeout, ...) when it never connected,
+// otherwise "not a proxy"
+func failureReason(r Result) string {
+    if r.Err == errClosed {
+        return "closed"
+    }
+    for _, a := range r.Attempts {
+        var ce *connectError
+        if errors.As(a.Err, &ce) {
+            if class := failureClass(ce.err); class != "protocol" {
+                return class
+            }
+            return "unreachable"
+        }
+    }
+    for _, a := range r.Attempts {
+        var re *realTargetError
+        if errors.As(a.Err, &re) {
+            return "judge ok, real target failed"
+        }
+    }
+    return "not a proxy"
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "sync"
+    "syscall"
+    "time"
+)
+
+const (
+    fdPause      = 500 * time.Millisecond // how long new dials wait after EMFILE/ENFILE
+    fdMaxRetries = 20                     // dial attempts before giving up on a target
+    fdWarnEvery  = 10 * time.Second       // rate limit for the exhaustion warning
+)
+
+// fdGuard pauses all new dials when the process runs out of file
+// descriptors, so targets aren't reported dead because of a local limit
+type fdGuard struct {
+    mu         sync.Mutex
+    pauseUntil time.Time
+    lastWarn   time.Time
+}
+
+// isFDExhausted reports whether err means no file descriptors were available
+func isFDExhausted(err error) bool {
+    return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// wait blocks until any active pause is over
+func (g *fdGuard) wait() {
+    g.mu.Lock()
+    d := time.Until(g.pauseUntil)
+    g.mu.Unlock()
+    if d > 0 {
+        time.Sleep(d)
+    }
+}
+
+// exhausted starts (or extends) a pause and warns, at most every fdWarnEvery
+func (g *fdGuard) exhausted(err error) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.pauseUntil = time.Now().Add(fdPause)
+    if time.Since(g.lastWarn) >= fdWarnEvery {
+        g.lastWarn = time.Now()
+        log.Printf("Out of file descriptors (%v); pausing new dials. Lower -workers or raise the open-files limit (ulimit -n)", err)
+    }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "io"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// fingerprintRule guesses proxy software from response text. The first
+// submatch, if any, is the version.
+type fingerprintRule struct {
+    name string
+    re   *regexp.Regexp
+}
+
+// fingerprintRules are tried in order; the first match wins. They match
+// the headers a proxy adds (Via, X-Cache, Proxy-Agent, X-Squid-Error),
+// error pages it generates, and what SOCKS servers send back to HTTP.
+var fingerprintRules = []fingerprintRule{
+    {"Squid", regexp.MustCompile(`(?i)\bsquid(?:/([\d.]+))?`)},
+    {"tinyproxy", regexp.MustCompile(`(?i)\btinyproxy(?:/([\d.]+))?`)},
+    {"Privoxy", regexp.MustCompile(`(?i)\bprivoxy(?:[ /]([\d.]+))?`)},
+    {"3proxy", regexp.MustCompile(`(?i)\b3proxy(?:[ /-]([\d.]+))?`)},
+    {"Polipo", regexp.MustCompile(`(?i)\bpolipo(?:[ /]([\d.]+))?`)},
+    {"CCProxy", regexp.MustCompile(`(?i)\bccproxy(?:[ /]([\d.]+))?`)},
+    {"MikroTik", regexp.MustCompile(`(?i)\bmikrotik httpproxy\b()`)},
+    {"Apache", regexp.MustCompile(`(?i)\bapache(?:/([\d.]+))?`)},
+    {"Tor", regexp.MustCompile(`Tor is not an HTTP Proxy()`)},
+    {"Dante", regexp.MustCompile(`(?i)\bdante(?:[ /]([\d.]+))?`)},
+}
+
+// proxyHeaders are the response headers set by the proxy itself rather
+// than by the test target
+var proxyHeaders = []string{"Via", "X-Cache", "Proxy-Agent", "X-Squid-Error"}
+
+// matchSoftware returns "Name version" (or just the name) for the first
+// rule that matches text, or ""
+func matchSoftware(text string) string {
+    for _, rule := range fingerprintRules {
+        if m := rule.re.FindStringSubmatch(text); m != nil {
+            if len(m) > 1 && m[1] != "" {
+                return rule.name + " " + m[1]
+            }
+            return rule.name
+        }
+    }
+    return ""
+}
+
+// fingerprintHTTP guesses the software behind an HTTP proxy from the
+// headers it added. Server belongs to the test target on a proxied
+// response, so it is only looked at when the proxy answered itself (a
+// non-2xx error page).
+func fingerprintHTTP(resp *httpResponse) string {
+    var evidence []string
+    for _, name := range proxyHeaders {
+        if v := resp.header(name); v != "" {
+            evidence = append(evidence, v)
+        }
+    }
+    if resp.Code < 200 || resp.Code > 299 {
+        evidence = append(evidence, string(resp.Raw))
+    }
+    return matchSoftware(strings.Join(evidence, "\n"))
+}
+
+// fingerprintSOCKS guesses the software behind a SOCKS proxy from how it
+// reacts to a plain HTTP request, which some servers answer with a
+// telling error (Tor's SOCKS port, for one)
+func (s *Scanner) fingerprintSOCKS(address string) string {
+    conn, err := s.dial(address)
+    if err != nil {
+        return ""
+    }
+    defer conn.Close()
+    conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+    conn.SetReadDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
+    reply, _ := io.ReadAll(io.LimitReader(conn, 1024))
+    return matchSoftware(string(reply))
+}
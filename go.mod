@@ -1,3 +1,10 @@
 module proxyscanner
 
 go 1.24.3
+
+require (
+	golang.org/x/net v0.45.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.29.0 // indirect
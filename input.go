@@ -0,0 +1,289 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Exit codes for input problems, one per failure class so scripts can
+// tell them apart
+const (
+    exitInputMissing = 3 // input file does not exist or cannot be read
+    exitInputEmpty   = 4 // input file has no usable lines
+    exitInputInvalid = 5 // every line in the input file failed to parse
+)
+
+// invalidSampleSize is how many offending lines are quoted in diagnostics
+const invalidSampleSize = 3
+
+// inputFetchTimeout bounds fetching an http(s):// input list
+const inputFetchTimeout = 30 * time.Second
+
+// skipWarn reports a skipped input line; -quiet-errors replaces it with a
+// no-op so found-proxy output is not drowned out by messy lists
+var skipWarn = log.Printf
+
+// inputFatal logs a message and exits with code
+func inputFatal(code int, format string, args ...interface{}) {
+    log.Printf(format, args...)
+    os.Exit(code)
+}
+
+// readInputFile reads an input list, exiting with a specific diagnostic
+// when the file is missing, unreadable or empty. what describes the
+// expected content, e.g. "one CIDR, IP range or IP per line". An
+// http(s):// source is fetched and saved to cache, which is used instead
+// when a later fetch fails.
+func readInputFile(filename, what, cache string) []string {
+    return readInput(filename, what, cache, cleanLine)
+}
+
+// readDataFile is readInputFile for lists whose values may contain '#'
+// (credentials, JSON lines): only whole-line comments are dropped
+func readDataFile(filename, what string) []string {
+    return readInput(filename, what, "", trimLine)
+}
+
+// readInput is readInputFile with clean in place of cleanLine
+func readInput(filename, what, cache string, clean func(string) string) []string {
+    if isURL(filename) {
+        lines, err := fetchInput(filename, cache, clean)
+        if err != nil {
+            cached, cerr := readLinesWith(cache, clean)
+            if cerr != nil {
+                inputFatal(exitInputMissing, "Cannot fetch %s: %v", filename, err)
+            }
+            log.Printf("Cannot fetch %s: %v; using cached copy %s", filename, err, cache)
+            lines = cached
+        }
+        if len(lines) == 0 {
+            inputFatal(exitInputEmpty, "%s is empty; add %s", filename, what)
+        }
+        return lines
+    }
+    path, err := filepath.Abs(filename)
+    if err != nil {
+        path = filename
+    }
+    lines, err := readLinesWith(filename, clean)
+    if os.IsNotExist(err) {
+        inputFatal(exitInputMissing, "%s not found (looked for %s); create it with %s", filename, path, what)
+    }
+    if err != nil {
+        inputFatal(exitInputMissing, "Cannot read %s (%s): %v", filename, path, err)
+    }
+    if len(lines) == 0 {
+        inputFatal(exitInputEmpty, "%s (%s) is empty; add %s", filename, path, what)
+    }
+    return lines
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+    var items []string
+    for _, item := range strings.Split(s, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+// isURL reports whether an input source should be fetched over HTTP
+func isURL(source string) bool {
+    return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// fetchInput downloads an input list, parses it like readLinesWith and,
+// on success, writes the raw body to cache (if not "") for later runs
+func fetchInput(url, cache string, clean func(string) string) ([]string, error) {
+    client := &http.Client{Timeout: inputFetchTimeout}
+    resp, err := client.Get(url)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("HTTP %s", resp.Status)
+    }
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    lines, err := parseLinesWith(strings.NewReader(string(body)), clean)
+    if err != nil {
+        return nil, err
+    }
+    if cache == "" {
+        return lines, nil
+    }
+    if err := os.MkdirAll(filepath.Dir(cache), os.ModePerm); err != nil {
+        log.Printf("Cannot cache %s: %v", url, err)
+    } else if err := os.WriteFile(cache, body, 0644); err != nil {
+        log.Printf("Cannot cache %s: %v", url, err)
+    }
+    return lines, nil
+}
+
+// expandTargets expands input lines to IPs, collecting the lines that
+// failed to parse
+func expandTargets(lines []string) ([]string, invalidLines) {
+    var ips []string
+    var bad invalidLines
+    for _, cidr := range lines {
+        expanded, err := expandTarget(cidr)
+        if err != nil {
+            skipWarn("Skipping invalid CIDR %s: %v", cidr, err)
+            bad.add(cidr)
+            continue
+        }
+        ips = append(ips, expanded...)
+    }
+    return ips, bad
+}
+
+// cidrIndex maps every IP in lines to the first line that contains it
+func cidrIndex(lines []string) map[string]string {
+    cidrOf := make(map[string]string)
+    for _, line := range lines {
+        ips, err := expandTarget(line)
+        if err != nil {
+            continue
+        }
+        for _, ip := range ips {
+            if _, ok := cidrOf[ip]; !ok {
+                cidrOf[ip] = line
+            }
+        }
+    }
+    return cidrOf
+}
+
+// parsePorts expands port and port-range lines, collecting the lines that
+// failed to parse
+func parsePorts(lines []string) ([]int, invalidLines) {
+    var ports []int
+    var bad invalidLines
+    for _, pr := range lines {
+        pr = strings.TrimSpace(pr)
+        startPort, endPort, err := parsePortLine(pr)
+        if err != nil {
+            skipWarn("Skipping invalid port %s: %v", pr, err)
+            bad.add(pr)
+            continue
+        }
+        for p := startPort; p <= endPort; p++ {
+            ports = append(ports, p)
+        }
+    }
+    return ports, bad
+}
+
+// parsePortLine parses a port or start-end port range, both ends within
+// 1-65535
+func parsePortLine(s string) (int, int, error) {
+    var start, end int
+    var err error
+    if strings.Contains(s, "-") {
+        if start, end, err = parsePortRange(s); err != nil {
+            return 0, 0, err
+        }
+    } else {
+        if start, err = strconv.Atoi(s); err != nil {
+            return 0, 0, err
+        }
+        end = start
+    }
+    for _, p := range []int{start, end} {
+        if p < 1 || p > 65535 {
+            return 0, 0, fmt.Errorf("port %d out of range 1-65535", p)
+        }
+    }
+    if start > end {
+        return 0, 0, fmt.Errorf("range start is after end")
+    }
+    return start, end, nil
+}
+
+// excludePorts returns ports without the excluded ones
+func excludePorts(ports []int, excluded map[int]bool) []int {
+    if len(excluded) == 0 {
+        return ports
+    }
+    kept := make([]int, 0, len(ports))
+    for _, port := range ports {
+        if !excluded[port] {
+            kept = append(kept, port)
+        }
+    }
+    return kept
+}
+
+// ipFamily returns 4 or 6 for ip
+func ipFamily(ip net.IP) int {
+    if ip.To4() != nil {
+        return 4
+    }
+    return 6
+}
+
+// filterFamily keeps the IPs of the given family (4 or 6); 0 keeps all
+func filterFamily(ips []string, family int) []string {
+    if family == 0 {
+        return ips
+    }
+    kept := make([]string, 0, len(ips))
+    for _, s := range ips {
+        if ip := net.ParseIP(s); ip != nil && ipFamily(ip) == family {
+            kept = append(kept, s)
+        }
+    }
+    return kept
+}
+
+// mergePorts appends the ports of extra that are not already in ports
+func mergePorts(ports, extra []int) []int {
+    seen := make(map[int]bool, len(ports))
+    for _, port := range ports {
+        seen[port] = true
+    }
+    for _, port := range extra {
+        if !seen[port] {
+            seen[port] = true
+            ports = append(ports, port)
+        }
+    }
+    return ports
+}
+
+// invalidLines collects lines that failed to parse, for the final diagnostic
+type invalidLines []string
+
+func (inv *invalidLines) add(line string) {
+    *inv = append(*inv, line)
+}
+
+// sample quotes the first few offending lines
+func (inv invalidLines) sample() string {
+    n := len(inv)
+    if n > invalidSampleSize {
+        n = invalidSampleSize
+    }
+    quoted := make([]string, n)
+    for i, line := range inv[:n] {
+        quoted[i] = fmt.Sprintf("%q", line)
+    }
+    s := strings.Join(quoted, ", ")
+    if len(inv) > n {
+        s += fmt.Sprintf(" and %d more", len(inv)-n)
+    }
+    return s
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParsePorts(t *testing.T) {
+    tests := []struct {
+        line string
+        want []int // nil for an invalid line
+    }{
+        {"8080", []int{8080}},
+        {" 1080 ", []int{1080}},
+        {"1", []int{1}},
+        {"65535", []int{65535}},
+        {"1080-1082", []int{1080, 1081, 1082}},
+        {"65534-65535", []int{65534, 65535}},
+        {"0", nil},
+        {"70000", nil},
+        {"-1", nil},
+        {"0-10", nil},
+        {"65530-70000", nil},
+        {"1082-1080", nil},
+        {"http", nil},
+    }
+    for _, tc := range tests {
+        got, bad := parsePorts([]string{tc.line})
+        if tc.want == nil {
+            if len(got) != 0 || len(bad) != 1 {
+                t.Errorf("parsePorts(%q) = %v, bad %v; want the line rejected", tc.line, got, bad)
+            }
+            continue
+        }
+        if len(bad) != 0 || !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("parsePorts(%q) = %v, bad %v; want %v", tc.line, got, bad, tc.want)
+        }
+    }
+}
+
+func TestValidateRejectsOutOfRangePorts(t *testing.T) {
+    dir := t.TempDir()
+    cidrs := filepath.Join(dir, "Cidr.txt")
+    ports := filepath.Join(dir, "Ports.txt")
+    if err := os.WriteFile(cidrs, []byte("192.0.2.0/30\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(ports, []byte("1080\n0\n70000\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    var out strings.Builder
+    if code := runValidate(validateSources{CIDRFile: cidrs, PortsFile: ports}, &out); code != exitInputInvalid {
+        t.Errorf("runValidate = %d, want %d\n%s", code, exitInputInvalid, out.String())
+    }
+    for _, want := range []string{`Ports.txt:2: invalid "0"`, `Ports.txt:3: invalid "70000"`, "Ports.txt: 1 ports", "4 tasks"} {
+        if !strings.Contains(out.String(), want) {
+            t.Errorf("validate output lacks %q:\n%s", want, out.String())
+        }
+    }
+}
+
+func TestValidateCountsWithoutExpanding(t *testing.T) {
+    dir := t.TempDir()
+    cidrs := filepath.Join(dir, "Cidr.txt")
+    ports := filepath.Join(dir, "Ports.txt")
+    if err := os.WriteFile(cidrs, []byte("10.0.0.0/8\n2001:db8::/64\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(ports, []byte("80\n443\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    done := make(chan struct{})
+    var out strings.Builder
+    var code int
+    go func() {
+        code = runValidate(validateSources{CIDRFile: cidrs, PortsFile: ports}, &out)
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("runValidate did not return; the blocks were expanded")
+    }
+    if code != 0 {
+        t.Errorf("runValidate = %d, want 0\n%s", code, out.String())
+    }
+    // 2^24 + 2^64 IPs, times two ports
+    for _, want := range []string{"18446744073726328832 IPs", "36893488147452657664 tasks"} {
+        if !strings.Contains(out.String(), want) {
+            t.Errorf("validate output lacks %q:\n%s", want, out.String())
+        }
+    }
+}
+
+func TestValidateInlineInputs(t *testing.T) {
+    tests := []struct {
+        name string
+        src  validateSources
+        code int
+        want []string
+    }{
+        {
+            name: "inline targets and ports replace the defaults",
+            src:  validateSources{CIDRFile: "Cidr.txt", PortsFile: "Ports.txt", Targets: "192.0.2.0/30,198.51.100.7", PortList: "1080,8080-8081"},
+            want: []string{"-targets: 5 IPs", "-port-list: 3 ports", "15 tasks"},
+        },
+        {
+            name: "services",
+            src:  validateSources{CIDRFile: "Cidr.txt", PortsFile: "Ports.txt", Targets: "192.0.2.1", Services: "socks"},
+            want: []string{"-services: 4 ports", "4 tasks"},
+        },
+        {
+            name: "invalid inline entries",
+            src:  validateSources{CIDRFile: "Cidr.txt", PortsFile: "Ports.txt", Targets: "192.0.2.1,not-an-ip", PortList: "1080,70000"},
+            code: exitInputInvalid,
+            want: []string{`-targets:2: invalid "not-an-ip"`, `-port-list:2: invalid "70000"`},
+        },
+        {
+            name: "unknown service",
+            src:  validateSources{CIDRFile: "Cidr.txt", PortsFile: "Ports.txt", Targets: "192.0.2.1", PortList: "80", Services: "nope"},
+            code: exitInputInvalid,
+            want: []string{`-services: invalid "nope"`},
+        },
+    }
+    // Cidr.txt and Ports.txt must not be read when the inline flags replace them
+    t.Chdir(t.TempDir())
+    for _, tc := range tests {
+        var out strings.Builder
+        if code := runValidate(tc.src, &out); code != tc.code {
+            t.Errorf("%s: runValidate = %d, want %d\n%s", tc.name, code, tc.code, out.String())
+        }
+        for _, want := range tc.want {
+            if !strings.Contains(out.String(), want) {
+                t.Errorf("%s: validate output lacks %q:\n%s", tc.name, want, out.String())
+            }
+        }
+    }
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+)
+
+var (
+    interruptMu    sync.Mutex
+    interruptHooks []func()
+    interruptOnce  sync.Once
+)
+
+// onInterrupt registers fn to run when SIGINT or SIGTERM arrives. Hooks
+// run in registration order, then the process exits with status 1.
+func onInterrupt(fn func()) {
+    interruptMu.Lock()
+    interruptHooks = append(interruptHooks, fn)
+    interruptMu.Unlock()
+    interruptOnce.Do(func() {
+        sig := make(chan os.Signal, 1)
+        signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+        go func() {
+            <-sig
+            interruptMu.Lock()
+            for _, hook := range interruptHooks {
+                hook()
+            }
+            os.Exit(1)
+        }()
+    })
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "math/rand"
+    "strings"
+    "time"
+)
+
+// jitterRange is the -jitter window each worker sleeps within before a dial
+type jitterRange struct {
+    min, max time.Duration
+}
+
+// parseJitter parses "MAX" or "MIN-MAX" durations, e.g. "500ms" or
+// "0-500ms". A bare number takes the unit of the other bound.
+func parseJitter(s string) (jitterRange, error) {
+    s = strings.TrimSpace(s)
+    if s == "" || s == "0" {
+        return jitterRange{}, nil
+    }
+    lo, hi, ok := strings.Cut(s, "-")
+    if !ok {
+        lo, hi = "0", s
+    }
+    unit := strings.TrimLeft(hi, "0123456789.")
+    if unit == "" {
+        unit = strings.TrimLeft(lo, "0123456789.")
+    }
+    if unit == "" {
+        return jitterRange{}, fmt.Errorf("missing unit, e.g. 0-500ms")
+    }
+    var j jitterRange
+    var err error
+    if j.min, err = parseJitterBound(lo, unit); err != nil {
+        return jitterRange{}, err
+    }
+    if j.max, err = parseJitterBound(hi, unit); err != nil {
+        return jitterRange{}, err
+    }
+    if j.min < 0 || j.max < j.min {
+        return jitterRange{}, fmt.Errorf("range must be min-max with 0 <= min <= max")
+    }
+    return j, nil
+}
+
+// parseJitterBound parses one bound, appending unit to a bare number
+func parseJitterBound(s, unit string) (time.Duration, error) {
+    if strings.TrimLeft(s, "0123456789.") == "" {
+        s += unit
+    }
+    return time.ParseDuration(s)
+}
+
+// workerRand returns a per-worker source for -jitter, so workers do not
+// contend on the global one
+func workerRand(i int) *rand.Rand {
+    return rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+}
+
+// sleep waits a uniformly random time within the range; rng must not be
+// shared between goroutines
+func (j jitterRange) sleep(rng *rand.Rand) {
+    if j.max <= 0 {
+        return
+    }
+    d := j.min
+    if j.max > j.min {
+        d += time.Duration(rng.Int63n(int64(j.max - j.min + 1)))
+    }
+    time.Sleep(d)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "regexp"
+    "strings"
+)
+
+// judgeHeaderRe finds Via and X-Forwarded-For in a judge page, the test
+// target's echo of the request headers it received. It matches the
+// common layouts: "Via: ..." lines, httpbin's JSON ("Via": "...") and
+// azenv-style CGI variables (HTTP_VIA = ...).
+var judgeHeaderRe = regexp.MustCompile(`(?im)^\W*(?:HTTP_)?(VIA|X[-_]FORWARDED[-_]FOR)\W*[:=]\s*"?([^"\r\n]*)`)
+
+// chainDepth counts the proxy hops a -judge response reveals: the Via
+// entries and X-Forwarded-For addresses echoed back by the judge, and
+// the Via entries added to the response on its way back, whichever is
+// most. An elite proxy reveals none and scores 0; more than 1 means the
+// proxy forwards to another proxy.
+func chainDepth(resp *httpResponse) int {
+    depth := hops(resp.header("Via"))
+    raw := string(resp.Raw)
+    if _, body, ok := strings.Cut(raw, "\r\n\r\n"); ok {
+        raw = body
+    } else if _, body, ok := strings.Cut(raw, "\n\n"); ok {
+        raw = body
+    } else {
+        return depth
+    }
+    var via, forwarded int
+    for _, m := range judgeHeaderRe.FindAllStringSubmatch(raw, -1) {
+        if strings.EqualFold(m[1], "via") {
+            via += hops(m[2])
+        } else {
+            forwarded += hops(m[2])
+        }
+    }
+    return max(depth, via, forwarded)
+}
+
+// hops counts the non-empty entries of a comma-separated header value
+func hops(value string) int {
+    n := 0
+    for _, entry := range strings.Split(value, ",") {
+        if strings.TrimSpace(entry) != "" {
+            n++
+        }
+    }
+    return n
+}
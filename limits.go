@@ -0,0 +1,90 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "sync/atomic"
+)
+
+// protocolLimits is -limit-per-protocol: how many proxies of each protocol
+// are wanted, with the finds so far shared by all workers. A protocol that
+// has reached its limit is no longer checked, and the scan stops once
+// every limit is met.
+type protocolLimits struct {
+    want  map[string]int64
+    found map[string]*atomic.Int64
+}
+
+// parseProtocolLimits parses "http=50,socks5=50". Protocol names are
+// those of -protocol-order, plus https-proxy and socks5-tls.
+func parseProtocolLimits(s string) (*protocolLimits, error) {
+    l := &protocolLimits{want: make(map[string]int64), found: make(map[string]*atomic.Int64)}
+    for _, part := range splitList(s) {
+        name, value, ok := strings.Cut(part, "=")
+        if !ok {
+            return nil, fmt.Errorf("%q: want protocol=count", part)
+        }
+        protocol := strings.ToUpper(strings.TrimSpace(name))
+        known := protocol == "HTTPS-PROXY" || protocol == "SOCKS5-TLS"
+        for _, p := range defaultOrder {
+            known = known || p == protocol
+        }
+        if !known {
+            return nil, fmt.Errorf("unknown protocol %q", name)
+        }
+        n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("%q: count must be a positive integer", part)
+        }
+        l.want[protocol] = n
+        l.found[protocol] = new(atomic.Int64)
+    }
+    if len(l.want) == 0 {
+        return nil, fmt.Errorf("no limits given")
+    }
+    return l, nil
+}
+
+// full reports whether protocol has reached its limit
+func (l *protocolLimits) full(protocol string) bool {
+    if l == nil {
+        return false
+    }
+    found, ok := l.found[protocol]
+    return ok && found.Load() >= l.want[protocol]
+}
+
+// add counts a find of protocol and reports whether it fits within the
+// limit; finds racing past it are not wanted
+func (l *protocolLimits) add(protocol string) bool {
+    if l == nil {
+        return true
+    }
+    found, ok := l.found[protocol]
+    return !ok || found.Add(1) <= l.want[protocol]
+}
+
+// done reports whether every limit has been met
+func (l *protocolLimits) done() bool {
+    if l == nil {
+        return false
+    }
+    for protocol := range l.want {
+        if !l.full(protocol) {
+            return false
+        }
+    }
+    return true
+}
+
+// String renders the limits as "HTTP=50, SOCKS5=50"
+func (l *protocolLimits) String() string {
+    parts := make([]string, 0, len(l.want))
+    for protocol, n := range l.want {
+        parts = append(parts, fmt.Sprintf("%s=%d", protocol, n))
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ", ")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "log"
+    "runtime"
+    "sync/atomic"
+    "time"
+)
+
+// memoryCheckInterval is how often -max-memory samples the heap
+const memoryCheckInterval = 500 * time.Millisecond
+
+// memoryGuard pauses the task feeder while the heap is over a soft limit
+// (-max-memory), so results and buffers can drain before more tasks are
+// queued. The heap is sampled in the background; the feeder only reads
+// the last sample.
+type memoryGuard struct {
+    limit  uint64
+    heap   atomic.Uint64
+    paused bool // only touched by the feeder
+}
+
+func newMemoryGuard(limitMiB int) *memoryGuard {
+    g := &memoryGuard{limit: uint64(limitMiB) << 20}
+    g.sample()
+    go func() {
+        for range time.Tick(memoryCheckInterval) {
+            g.sample()
+        }
+    }()
+    return g
+}
+
+func (g *memoryGuard) sample() uint64 {
+    var ms runtime.MemStats
+    runtime.ReadMemStats(&ms)
+    g.heap.Store(ms.HeapAlloc)
+    return ms.HeapAlloc
+}
+
+// wait blocks while the heap is over the limit. Garbage counts towards
+// HeapAlloc until collected, so while paused each check forces a GC.
+func (g *memoryGuard) wait() {
+    if g == nil || g.heap.Load() <= g.limit {
+        return
+    }
+    for {
+        runtime.GC()
+        heap := g.sample()
+        if heap <= g.limit {
+            break
+        }
+        if !g.paused {
+            g.paused = true
+            log.Printf("Heap at %d MiB, over -max-memory %d MiB: pausing the task feeder", heap>>20, g.limit>>20)
+        }
+        time.Sleep(memoryCheckInterval)
+    }
+    if g.paused {
+        g.paused = false
+        log.Printf("Heap back under -max-memory, resuming the task feeder")
+    }
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "log"
+    "os"
+    "runtime"
+    "runtime/pprof"
+    "sync"
+)
+
+// startProfiles starts a CPU profile into cpuPath and returns a stop
+// function that ends it and writes a heap profile to memPath. Empty paths
+// disable either profile. While profiling, SIGINT/SIGTERM also stop the
+// profiles before exiting so interrupted and daemon runs still get one.
+func startProfiles(cpuPath, memPath string) (func(), error) {
+    if cpuPath == "" && memPath == "" {
+        return func() {}, nil
+    }
+    var cpuFile *os.File
+    if cpuPath != "" {
+        f, err := os.Create(cpuPath)
+        if err != nil {
+            return nil, err
+        }
+        if err := pprof.StartCPUProfile(f); err != nil {
+            f.Close()
+            return nil, err
+        }
+        cpuFile = f
+    }
+
+    var once sync.Once
+    stop := func() {
+        once.Do(func() {
+            if cpuFile != nil {
+                pprof.StopCPUProfile()
+                cpuFile.Close()
+            }
+            if memPath != "" {
+                f, err := os.Create(memPath)
+                if err != nil {
+                    log.Printf("Cannot write memory profile: %v", err)
+                    return
+                }
+                defer f.Close()
+                runtime.GC()
+                if err := pprof.WriteHeapProfile(f); err != nil {
+                    log.Printf("Cannot write memory profile: %v", err)
+                }
+            }
+        })
+    }
+
+    onInterrupt(stop)
+    return stop, nil
+}
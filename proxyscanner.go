@@ -2,322 +2,2830 @@ package main
 
 import (
     "bufio"
+    "bytes"
+    "context"
+    "crypto/tls"
     "encoding/json"
+    "errors"
     "flag"
     "fmt"
+    "io"
     "log"
+    "math/big"
+    "math/rand"
     "net"
+    "net/http"
+    "net/url"
     "os"
+    "path/filepath"
     "runtime"
     "strconv"
     "strings"
     "sync"
     "time"
+
+    "golang.org/x/net/proxy"
+    "gopkg.in/yaml.v3"
 )
 
-// Config holds CLI/configuration parameters
-type Config struct {
-    Timeout         int    `json:"timeout"`
-    Workers         int    `json:"workers"`
-    RefreshInterval int    `json:"refresh_interval"`
-    OutputDir       string `json:"output_dir"`
-    LogLevel        string `json:"log_level"`
+// Config holds CLI/configuration parameters
+type Config struct {
+    Timeout            int    `json:"timeout" yaml:"timeout"`
+    ConnectTimeout     int    `json:"connect_timeout" yaml:"connect_timeout"`
+    Workers            int    `json:"workers" yaml:"workers"`
+    Cidr               string `json:"cidr" yaml:"cidr"`
+    Ports              string `json:"ports" yaml:"ports"`
+    QuietErrors        bool   `json:"quiet_errors" yaml:"quiet_errors"`
+    Enrich             string `json:"enrich" yaml:"enrich"`
+    NoOutput           bool   `json:"no_output" yaml:"no_output"`
+    FirstMatchPerHost  bool   `json:"first_match_per_host" yaml:"first_match_per_host"`
+    Timestamped        bool   `json:"timestamped" yaml:"timestamped"`
+    BandwidthTest      string `json:"bandwidth_test" yaml:"bandwidth_test"`
+    BandwidthBytes     int64  `json:"bandwidth_bytes" yaml:"bandwidth_bytes"`
+    MaxHostTime        string `json:"max_host_time" yaml:"max_host_time"`
+    Replay             string `json:"replay" yaml:"replay"`
+    HTTPSProxy         bool   `json:"https_proxy" yaml:"https_proxy"`
+    TLSSNI             string `json:"tls_sni" yaml:"tls_sni"`
+    TLSVerify          bool   `json:"tls_verify" yaml:"tls_verify"`
+    PerCIDRConcurrency int    `json:"per_cidr_concurrency" yaml:"per_cidr_concurrency"`
+    MaxOutputSize      int64  `json:"max_output_size" yaml:"max_output_size"`
+    OutputRotations    int    `json:"output_rotations" yaml:"output_rotations"`
+    ExcludePorts       string `json:"exclude_ports" yaml:"exclude_ports"`
+    TargetsJSONL       string `json:"targets_jsonl" yaml:"targets_jsonl"`
+    Jitter             string `json:"jitter" yaml:"jitter"`
+    HTTPMethod         string `json:"http_method" yaml:"http_method"`
+    HeadFallback       bool   `json:"head_fallback" yaml:"head_fallback"`
+    OutputEncoding     string `json:"output_encoding" yaml:"output_encoding"`
+    DialFrom           string `json:"dial_from" yaml:"dial_from"`
+    RetryDeadAfter     string `json:"retry_dead_after" yaml:"retry_dead_after"`
+    FollowRedirect     bool   `json:"follow_redirect" yaml:"follow_redirect"`
+    WorkerStats        string `json:"worker_stats" yaml:"worker_stats"`
+    Services           string `json:"services" yaml:"services"`
+    TFO                bool   `json:"tfo" yaml:"tfo"`
+    GreetRetries       int    `json:"socks5_greet_retries" yaml:"socks5_greet_retries"`
+    ExportFormat       string `json:"export_format" yaml:"export_format"`
+    MaxErrors          int    `json:"max_errors" yaml:"max_errors"`
+    Targets            string `json:"targets" yaml:"targets"`
+    PortList           string `json:"port_list" yaml:"port_list"`
+    Fingerprint        bool   `json:"fingerprint" yaml:"fingerprint"`
+    Heartbeat          string `json:"heartbeat" yaml:"heartbeat"`
+    IPv4Only           bool   `json:"ipv4_only" yaml:"ipv4_only"`
+    IPv6Only           bool   `json:"ipv6_only" yaml:"ipv6_only"`
+    SeenDB             string `json:"seen_db" yaml:"seen_db"`
+    SeenTTL            string `json:"seen_ttl" yaml:"seen_ttl"`
+    OutputAppendJSONL  string `json:"output_append_jsonl" yaml:"output_append_jsonl"`
+    ReportOpenTCP      bool   `json:"report_open_tcp" yaml:"report_open_tcp"`
+    Reverse            bool   `json:"reverse" yaml:"reverse"`
+    MaxConnections     int    `json:"max_connections" yaml:"max_connections"`
+    Judge              bool   `json:"judge" yaml:"judge"`
+    TaskBuffer         int    `json:"task_buffer" yaml:"task_buffer"`
+    ResultBuffer       int    `json:"result_buffer" yaml:"result_buffer"`
+    OutputHash         bool   `json:"output_hash" yaml:"output_hash"`
+    SOCKS4Ident        bool   `json:"socks4_ident" yaml:"socks4_ident"`
+    AdaptiveTimeout    bool   `json:"dial_timeout_adaptive" yaml:"dial_timeout_adaptive"`
+    DialTimeoutMin     string `json:"dial_timeout_min" yaml:"dial_timeout_min"`
+    DialTimeoutMax     string `json:"dial_timeout_max" yaml:"dial_timeout_max"`
+    RecordFailures     string `json:"record_failures" yaml:"record_failures"`
+    CheckBind          bool   `json:"check_bind" yaml:"check_bind"`
+    LimitPerProtocol   string `json:"limit_per_protocol" yaml:"limit_per_protocol"`
+    TimeoutHistogram   bool   `json:"timeout_distribution" yaml:"timeout_distribution"`
+    Interface          string `json:"interface" yaml:"interface"`
+    SkipWarmup         bool   `json:"skip_warmup" yaml:"skip_warmup"`
+    Tag                string `json:"tag" yaml:"tag"`
+    CompressOutput     bool   `json:"compress_output" yaml:"compress_output"`
+    MaxMemory          int    `json:"max_memory" yaml:"max_memory"`
+    RealTarget         string `json:"real_target" yaml:"real_target"`
+    CredentialsFile    string `json:"proxy_credentials_file" yaml:"proxy_credentials_file"`
+    MaxPorts           int    `json:"max_ports" yaml:"max_ports"`
+    MaxTasks           int    `json:"max_tasks" yaml:"max_tasks"`
+    Force              bool   `json:"force" yaml:"force"`
+    Color              string `json:"color" yaml:"color"`
+    AdaptiveOrder      bool   `json:"adaptive_order" yaml:"adaptive_order"`
+    WriteInterval      string `json:"write_interval" yaml:"write_interval"`
+    SourcePorts        string `json:"source_ports" yaml:"source_ports"`
+    MinSuccessRate     string `json:"min_success_rate" yaml:"min_success_rate"`
+    MinSuccessTries    int    `json:"min_success_attempts" yaml:"min_success_attempts"`
+    SOCKSTLS           bool   `json:"socks_tls" yaml:"socks_tls"`
+    RefreshInterval    int    `json:"refresh_interval" yaml:"refresh_interval"`
+    OutputDir          string `json:"output_dir" yaml:"output_dir"`
+    Out                string `json:"out" yaml:"out"`
+    LogLevel           string `json:"log_level" yaml:"log_level"`
+    ASNDB              string `json:"asn_db" yaml:"asn_db"`
+    IncludeASN         string `json:"include_asn" yaml:"include_asn"`
+    ExcludeASN         string `json:"exclude_asn" yaml:"exclude_asn"`
+    Through            string `json:"through" yaml:"through"`
+    BackoffAfter       int    `json:"backoff_after" yaml:"backoff_after"`
+    BackoffMax         int    `json:"backoff_max" yaml:"backoff_max"`
+    AcceptStatus       string `json:"accept_status" yaml:"accept_status"`
+    MaxResponseBytes   int64  `json:"max_response_bytes" yaml:"max_response_bytes"`
+    HTTPVersion        string `json:"http_version" yaml:"http_version"`
+    ProtocolOrder      string `json:"protocol_order" yaml:"protocol_order"`
+    TestURLs           string `json:"test_urls" yaml:"test_urls"`
+    TestIPs            string `json:"test_ips" yaml:"test_ips"`
+    ResolveOnce        bool   `json:"resolve_once" yaml:"resolve_once"`
+    DNSTTL             int    `json:"dns_ttl" yaml:"dns_ttl"`
+    Daemon             bool   `json:"daemon" yaml:"daemon"`
+    EvictAfter         int    `json:"evict_after" yaml:"evict_after"`
+    Mode               string `json:"mode" yaml:"mode"`
+    Shuffle            bool   `json:"shuffle" yaml:"shuffle"`
+    Seed               int64  `json:"seed" yaml:"seed"`
+    CustomCheck        string `json:"custom_check" yaml:"custom_check"`
+    MinLatency         string `json:"min_latency" yaml:"min_latency"`
+    MaxLatency         string `json:"max_latency" yaml:"max_latency"`
+    Summary            bool   `json:"summary" yaml:"summary"`
+    APIAddr            string `json:"api_addr" yaml:"api_addr"`
+    APIToken           string `json:"api_token" yaml:"api_token"`
+}
+
+// Scanner holds the settings shared by the proxy checks
+type Scanner struct {
+    Timeout int    // per-check connect/read timeout (seconds)
+    Dialer  Dialer // opens check connections, directly or via -through
+    TFO     bool   // -tfo on a direct Dialer: connects return before the handshake
+
+    Mode        string        // "proxy", or "portscan" to only test for open ports
+    OpenTimeout time.Duration // connect timeout in portscan mode
+    GrabBanner  int           // bytes of banner to record when no check passes, 0 disables
+
+    AcceptStatus     statusSet       // HTTP status codes that count as a working proxy
+    MaxResponseBytes int64           // cap on how much of an HTTP response is read
+    HTTPVersion      string          // request version for HTTP checks, "1.0" or "1.1"
+    HTTPMethod       string          // request method for HTTP checks, "GET" or "HEAD"
+    HeadFallback     bool            // retry with HEAD when GET is answered with 405
+    FollowRedirect   bool            // follow one 3xx in HTTP checks
+    Order            []string        // protocol check cascade, first match wins
+    GreetRetries     int             // SOCKS5 re-greets after a truncated or malformed method reply
+    Fingerprint      bool            // guess the proxy software of matches
+    Judge            bool            // read the echoed request headers for proxy chains
+    CheckBind        bool            // probe SOCKS5 matches for BIND support
+    Limits           *protocolLimits // -limit-per-protocol, nil disables
+    RealTarget       *testTarget     // -real-target HTTP proxies must also reach, nil disables
+    Credentials      credentials     // per-proxy auth, -proxy-credentials-file and -targets-jsonl
+    Reorder          *adaptiveOrder  // -adaptive-order, nil keeps Order
+    ReportOpenTCP    bool            // report connectable ports no check matched as OPEN-TCP
+    SOCKS4Ident      bool            // record SOCKS4 servers failing on ident as "ident required"
+
+    HTTPTargets   *targetPool // -test-urls, used by HTTP and SOCKS5 checks
+    SOCKS4Targets *targetPool // -test-ips, used by the SOCKS4 check
+    DNS           *dnsCache   // resolved target hostnames
+    ResolveOnce   bool        // send cached IPs instead of hostnames to SOCKS5
+
+    Backoff *dialBackoff // slows dials during timeout streaks, nil disables
+    // Adaptive replaces the connect timeout with one calibrated from
+    // observed connect times (-dial-timeout-adaptive), nil disables
+    Adaptive *adaptiveTimeout
+    FDs      *fdGuard // pauses dials while file descriptors are exhausted
+
+    CustomCheck string // external check program, run after the built-in checks
+
+    TLS *tls.Config // HTTPS-PROXY and SOCKS5-TLS check settings (-tls-sni, -tls-verify)
+
+    Trace io.Writer // -self-test: hex dump of all check traffic, nil disables
+}
+
+func main() {
+    // --- CLI Flags ---
+    timeout := flag.Int("timeout", 3, "connection timeout (seconds)")
+    connectTimeout := flag.Int("connect-timeout", 0, "TCP pre-scan timeout (milliseconds, 0 disables the pre-scan)")
+    workers := flag.Int("workers", runtime.NumCPU()*2, "number of concurrent workers")
+    cidrFile := flag.String("cidr", "Cidr.txt", "file or http(s):// URL with target CIDRs, ranges or IPs")
+    portsFile := flag.String("ports", "Ports.txt", "file or http(s):// URL with target ports")
+    quietErrors := flag.Bool("quiet-errors", false, "suppress warnings about skipped input lines")
+    enrich := flag.String("enrich", "", "comma-separated lookups for found proxies: rdns, whois")
+    noOutput := flag.Bool("no-output", false, "write no output file, only count results (for benchmarking)")
+    selfTest := flag.String("self-test", "", "run every check against IP:PORT with hex dumps of the traffic, then exit")
+    firstMatch := flag.Bool("first-match-per-host", false, "stop scanning a host's other ports once one yields a result")
+    cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to this file")
+    memProfile := flag.String("memprofile", "", "write a heap profile to this file on exit")
+    timestamped := flag.Bool("timestamped", false, "write this run's files to a <output-dir>/YYYYMMDD-HHMMSS subdirectory")
+    bandwidthTest := flag.String("bandwidth-test", "", "http:// URL to download through each found proxy to measure throughput")
+    bandwidthBytes := flag.Int64("bandwidth-bytes", 1<<20, "stop the -bandwidth-test download after this many bytes")
+    maxHostTime := flag.Duration("max-host-time", 0, "abandon a host's remaining ports after this much total scan time on it (e.g. 30s, 0 disables)")
+    replay := flag.String("replay", "", "re-test the addresses in an earlier proxies.txt or JSON results file instead of -cidr/-ports")
+    httpsProxy := flag.Bool("https-proxy", false, "also check for HTTPS-terminating proxies (HTTP proxy requests over TLS), after the other checks")
+    tlsSNI := flag.String("tls-sni", "", "server name sent in the HTTPS-PROXY and SOCKS5-TLS checks' TLS handshake")
+    tlsVerify := flag.Bool("tls-verify", false, "verify the HTTPS-PROXY and SOCKS5-TLS certificates against -tls-sni")
+    perCIDR := flag.Int("per-cidr-concurrency", 0, "max simultaneous tasks per input CIDR line (0 = unlimited)")
+    maxOutputSize := flag.Int64("max-output-size", 0, "rotate the output file once it reaches this many bytes (0 disables)")
+    outputRotations := flag.Int("output-rotations", 5, "rotated output files to keep with -max-output-size")
+    validate := flag.Bool("validate", false, "check -cidr, -ports, -targets, -port-list and -services, report invalid lines and totals, then exit")
+    excludePortList := flag.String("exclude-ports", "", "comma-separated ports or ranges never to scan (e.g. 2222,8000-8010)")
+    targetsJSONL := flag.String("targets-jsonl", "", "JSON lines file of tagged targets ({\"ip\",\"port\"} or {\"cidr\",\"ports\"}) instead of -cidr/-ports")
+    jitterFlag := flag.String("jitter", "", "random sleep before each dial, per worker (e.g. 0-500ms)")
+    httpMethod := flag.String("http-method", "GET", "method for HTTP proxy checks (GET|HEAD)")
+    headFallback := flag.Bool("head-fallback", false, "retry HTTP checks with HEAD when GET is answered with 405")
+    outputEncoding := flag.String("output-encoding", "plain", "output line format (plain|url|base64)")
+    dialFrom := flag.String("dial-from", "", "local IP to originate check connections from")
+    retryDeadAfter := flag.Duration("retry-dead-after", 0, "daemon mode: re-test a failing proxy only once this long has passed since its last check (e.g. 6h, 0 re-tests every tick)")
+    followRedirect := flag.Bool("follow-redirect", false, "follow one 3xx redirect in HTTP checks and judge the final status")
+    workerStats := flag.Duration("worker-stats", 0, "log what each worker is doing at this interval (e.g. 10s, 0 disables)")
+    services := flag.String("services", "", "comma-separated service names whose ports are scanned, merged with -ports (e.g. http,socks,http-proxy)")
+    tfo := flag.Bool("tfo", false, "use TCP Fast Open for check connections where the OS supports it (Linux)")
+    greetRetries := flag.Int("socks5-greet-retries", 1, "re-dial and re-greet a SOCKS5 server this many times after a truncated or malformed method reply")
+    exportFormat := flag.String("export-format", "", "comma-separated configs to write the working proxies to after each scan (squid, haproxy, yaml)")
+    maxErrors := flag.Int("max-errors", 0, "abort when this many targets in a row are unreachable and so is the first -test-urls host (0 disables)")
+    targetList := flag.String("targets", "", "comma-separated CIDRs, IP ranges or IPs to scan, instead of the default Cidr.txt or merged with -cidr")
+    portList := flag.String("port-list", "", "comma-separated ports or ranges to scan, instead of the default Ports.txt or merged with -ports")
+    fingerprint := flag.Bool("fingerprint", false, "guess the software (Squid, 3proxy, Tor...) behind found proxies")
+    heartbeat := flag.Duration("heartbeat", 30*time.Second, "log a still-alive line after this long without a find (0 disables)")
+    ipv4Only := flag.Bool("ipv4-only", false, "scan only the IPv4 targets and resolve test hosts to IPv4")
+    ipv6Only := flag.Bool("ipv6-only", false, "scan only the IPv6 targets and resolve test hosts to IPv6")
+    seenDBPath := flag.String("seen-db", "", "JSON lines file of past outcomes; addresses checked within -seen-ttl are not dialed again")
+    seenTTL := flag.Duration("seen-ttl", 24*time.Hour, "how long a -seen-db outcome is trusted")
+    appendJSONL := flag.String("output-append-jsonl", "", "also append every find as a versioned JSON event to this file")
+    reportOpenTCP := flag.Bool("report-open-tcp", false, "write ports that accept connections but fail every check as OPEN-TCP")
+    reverse := flag.Bool("reverse", false, "check each -replay proxy against every -test-urls target and write a CSV reachability matrix")
+    maxConnections := flag.Int("max-connections", 0, "cap on connections open at once across all workers, independent of -workers (0 = no cap)")
+    judge := flag.Bool("judge", false, "treat -test-urls as judges that echo request headers and record the proxy chain depth of HTTP proxies")
+    taskBuffer := flag.Int("task-buffer", 0, "tasks queued ahead of the workers; the feeder blocks when it is full (0 = 2 per worker)")
+    resultBuffer := flag.Int("result-buffer", 100, "found proxies queued ahead of the output writer")
+    outputHash := flag.Bool("output-hash", false, "write the SHA-256 of the output file to a .sha256 sidecar and print it in the summary")
+    socks4Ident := flag.Bool("socks4-ident", false, "record SOCKS4 servers that reject with ident errors (0x5C/0x5D) as SOCKS4 (ident required)")
+    adaptiveDial := flag.Bool("dial-timeout-adaptive", false, "calibrate the connect timeout from the median of recent successful connects, within -dial-timeout-min and -dial-timeout-max")
+    adaptiveMin := flag.Duration("dial-timeout-min", 200*time.Millisecond, "lower bound of the -dial-timeout-adaptive connect timeout")
+    adaptiveMax := flag.Duration("dial-timeout-max", 0, "upper bound of the -dial-timeout-adaptive connect timeout (0 = -timeout)")
+    recordFailures := flag.String("record-failures", "", "also write IP:PORT - reason for every failed task to this file")
+    checkBind := flag.Bool("check-bind", false, "probe SOCKS5 proxies for BIND support and record it as SOCKS5-BIND")
+    limitPerProtocol := flag.String("limit-per-protocol", "", "stop checking a protocol once this many are found, and the scan once all are met, e.g. http=50,socks5=50")
+    timeoutDistribution := flag.Bool("timeout-distribution", false, "add a histogram of successful check durations, per protocol, to the summary")
+    iface := flag.String("interface", "", "send all dials out of this network interface (e.g. tun0), regardless of the routing table")
+    skipWarmup := flag.Bool("skip-warmup", false, "do not check that the test targets are reachable directly before scanning")
+    runTag := flag.String("tag", "", "label stamped on every result, in the text output too (default: a generated run ID, JSON outputs only)")
+    compressOutput := flag.Bool("compress-output", false, "gzip the output file (the default name becomes proxies.txt.gz)")
+    maxMemory := flag.Int("max-memory", 0, "soft heap limit in MiB: the task feeder pauses while the heap is over it (0 disables)")
+    realTarget := flag.String("real-target", "", "URL HTTP proxies must also fetch, after the -test-urls check, to be reported (catches proxies that only allow some hosts)")
+    benchmark := flag.String("benchmark", "", "run the SOCKS5 check in a loop against IP:PORT, or \"builtin\" for an in-process server, and report checks/sec, p50/p99 and the error rate, then exit")
+    benchmarkDuration := flag.Duration("benchmark-duration", 10*time.Second, "how long -benchmark runs")
+    credentialsFile := flag.String("proxy-credentials-file", "", "file of IP:PORT user:pass lines; the checks authenticate to each listed proxy with its own credentials")
+    maxPorts := flag.Int("max-ports", 1000, "refuse to scan more ports than this after range expansion, unless -force (0 disables)")
+    maxTasks := flag.Int("max-tasks", 10000000, "refuse to scan more tasks (IPs × ports) than this, unless -force (0 disables)")
+    force := flag.Bool("force", false, "scan even when -max-ports or -max-tasks is exceeded")
+    colorMode := flag.String("color", "auto", "color the log output: auto (only on a terminal), always or never")
+    reorder := flag.Bool("adaptive-order", false, "try the protocol found most often so far first, instead of always following -protocol-order")
+    writeInterval := flag.Duration("write-interval", time.Second, "how often buffered output lines are flushed to disk (0 flushes every line)")
+    sourcePortRange := flag.String("source-ports", "", "dial from source ports in this start-end range, round-robin, instead of OS-chosen ephemeral ports")
+    minSuccessRate := flag.String("min-success-rate", "", "abandon the rest of a -cidr line once under this percentage of its tasks found anything (e.g. 0.5%)")
+    minSuccessTries := flag.Int("min-success-attempts", 256, "tasks a -cidr line must have finished before -min-success-rate judges it")
+    socksTLS := flag.Bool("socks-tls", false, "also check for SOCKS5 wrapped in TLS (SOCKS5-TLS), after the other checks")
+    refreshInterval := flag.Int("refresh-interval", 60, "interval to re-test proxies (minutes)")
+    shuffle := flag.Bool("shuffle", false, "scan targets in random order")
+    seed := flag.Int64("seed", 0, "seed for -shuffle; the same input and seed give the same order (0 picks one and logs it)")
+    mode := flag.String("mode", "proxy", "scan mode: proxy (detect proxies) or portscan (only report open ports)")
+    daemon := flag.Bool("daemon", false, "keep running, re-testing found proxies every refresh interval")
+    evictAfter := flag.Int("evict-after", 3, "daemon mode: drop a proxy after this many consecutive failed re-tests")
+    outputDir := flag.String("output-dir", ".", "directory for output file(s)")
+    out := flag.String("out", "", "output file path, or - for stdout (default <output-dir>/proxies.txt)")
+    logLevel := flag.String("log-level", "info", "log level (info|debug|quiet)")
+    asnDB := flag.String("asn-db", "", "prefix-to-ASN table (\"CIDR ASN\" per line)")
+    includeASN := flag.String("include-asn", "", "comma-separated ASNs to scan exclusively (requires -asn-db)")
+    excludeASN := flag.String("exclude-asn", "", "comma-separated ASNs to skip (requires -asn-db)")
+    testURLs := flag.String("test-urls", "http://www.google.com/", "comma-separated http:// targets for HTTP and SOCKS5 checks, used round-robin")
+    testIPs := flag.String("test-ips", "142.250.74.68:80", "comma-separated host:port targets for SOCKS4 checks, used round-robin")
+    resolveOnce := flag.Bool("resolve-once", false, "resolve test target hostnames at startup and send SOCKS5 proxies the cached IP")
+    dnsTTL := flag.Int("dns-ttl", 300, "how long resolved test target addresses are reused (seconds)")
+    protocolOrder := flag.String("protocol-order", "http,socks4,socks5", "order in which protocol checks run; first match wins")
+    acceptStatus := flag.String("accept-status", "2xx", "HTTP status codes counted as working (e.g. 2xx,301-302)")
+    httpVersion := flag.String("http-version", "1.1", "HTTP version for proxy requests (1.0|1.1)")
+    maxResponseBytes := flag.Int64("max-response-bytes", 16384, "maximum bytes of an HTTP proxy response to read")
+    minLatency := flag.Duration("min-latency", 0, "only output proxies at least this slow (e.g. 50ms)")
+    maxLatency := flag.Duration("max-latency", 0, "only output proxies at most this slow (e.g. 800ms)")
+    customCheck := flag.String("custom-check", "", "program run as \"prog IP PORT TIMEOUT\" after built-in checks; exit 0 = working, stdout = label")
+    grabBannerBytes := flag.Int("grab-banner", 0, "on open ports with no proxy detected, record up to N banner bytes (0 disables)")
+    backoffAfter := flag.Int("backoff-after", 0, "back off after this many consecutive dial timeouts (0 disables)")
+    backoffMax := flag.Int("backoff-max", 10, "maximum backoff delay before each dial (seconds)")
+    through := flag.String("through", "", "upstream proxy to dial all checks through (socks5://host:port)")
+    summaryFile := flag.Bool("summary", false, "also write a JSON summary to <output-dir>/summary.json")
+    apiAddr := flag.String("api-addr", "", "serve the control API on this address (e.g. 127.0.0.1:8080)")
+    apiToken := flag.String("api-token", "", "bearer token required for POST requests to the control API")
+    configFile := flag.String("config", "", "JSON or YAML (.yml/.yaml) config file (optional)")
+    printConfig := flag.Bool("print-config", false, "print every setting as JSON after merging -config, then exit")
+    dumpSchema := flag.Bool("dump-schema", false, "print a JSON Schema of the config file, -output-append-jsonl events, API proxies and summary.json, then exit")
+    flag.Parse()
+    log.SetOutput(logOut)
+
+    // --- Load Config from File if Provided ---
+    if *configFile != "" {
+        file, err := os.Open(*configFile)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error opening config file: %v\n", err)
+            os.Exit(1)
+        }
+        defer file.Close()
+        cfg := Config{}
+        ext := strings.ToLower(filepath.Ext(*configFile))
+        if ext == ".yml" || ext == ".yaml" {
+            if err := yaml.NewDecoder(file).Decode(&cfg); err != nil {
+                fmt.Fprintf(os.Stderr, "Invalid YAML config: %v\n", err)
+                os.Exit(1)
+            }
+        } else if err := json.NewDecoder(file).Decode(&cfg); err != nil {
+            fmt.Fprintf(os.Stderr, "Invalid JSON config: %v\n", err)
+            os.Exit(1)
+        }
+        if *timeout == 3 && cfg.Timeout != 0 {
+            *timeout = cfg.Timeout
+        }
+        if *connectTimeout == 0 && cfg.ConnectTimeout != 0 {
+            *connectTimeout = cfg.ConnectTimeout
+        }
+        if *workers == runtime.NumCPU()*2 && cfg.Workers != 0 {
+            *workers = cfg.Workers
+        }
+        if *cidrFile == "Cidr.txt" && cfg.Cidr != "" {
+            *cidrFile = cfg.Cidr
+        }
+        if *portsFile == "Ports.txt" && cfg.Ports != "" {
+            *portsFile = cfg.Ports
+        }
+        if !*quietErrors && cfg.QuietErrors {
+            *quietErrors = true
+        }
+        if *enrich == "" && cfg.Enrich != "" {
+            *enrich = cfg.Enrich
+        }
+        if !*noOutput && cfg.NoOutput {
+            *noOutput = true
+        }
+        if !*firstMatch && cfg.FirstMatchPerHost {
+            *firstMatch = true
+        }
+        if !*timestamped && cfg.Timestamped {
+            *timestamped = true
+        }
+        if *bandwidthTest == "" && cfg.BandwidthTest != "" {
+            *bandwidthTest = cfg.BandwidthTest
+        }
+        if *bandwidthBytes == 1<<20 && cfg.BandwidthBytes != 0 {
+            *bandwidthBytes = cfg.BandwidthBytes
+        }
+        if *maxHostTime == 0 && cfg.MaxHostTime != "" {
+            if d, err := time.ParseDuration(cfg.MaxHostTime); err == nil {
+                *maxHostTime = d
+            }
+        }
+        if *replay == "" && cfg.Replay != "" {
+            *replay = cfg.Replay
+        }
+        if !*httpsProxy && cfg.HTTPSProxy {
+            *httpsProxy = true
+        }
+        if *tlsSNI == "" && cfg.TLSSNI != "" {
+            *tlsSNI = cfg.TLSSNI
+        }
+        if !*tlsVerify && cfg.TLSVerify {
+            *tlsVerify = true
+        }
+        if *perCIDR == 0 && cfg.PerCIDRConcurrency != 0 {
+            *perCIDR = cfg.PerCIDRConcurrency
+        }
+        if *maxOutputSize == 0 && cfg.MaxOutputSize != 0 {
+            *maxOutputSize = cfg.MaxOutputSize
+        }
+        if *outputRotations == 5 && cfg.OutputRotations != 0 {
+            *outputRotations = cfg.OutputRotations
+        }
+        if *excludePortList == "" && cfg.ExcludePorts != "" {
+            *excludePortList = cfg.ExcludePorts
+        }
+        if *targetsJSONL == "" && cfg.TargetsJSONL != "" {
+            *targetsJSONL = cfg.TargetsJSONL
+        }
+        if *jitterFlag == "" && cfg.Jitter != "" {
+            *jitterFlag = cfg.Jitter
+        }
+        if *httpMethod == "GET" && cfg.HTTPMethod != "" {
+            *httpMethod = cfg.HTTPMethod
+        }
+        if !*headFallback && cfg.HeadFallback {
+            *headFallback = true
+        }
+        if *outputEncoding == "plain" && cfg.OutputEncoding != "" {
+            *outputEncoding = cfg.OutputEncoding
+        }
+        if *dialFrom == "" && cfg.DialFrom != "" {
+            *dialFrom = cfg.DialFrom
+        }
+        if *retryDeadAfter == 0 && cfg.RetryDeadAfter != "" {
+            if d, err := time.ParseDuration(cfg.RetryDeadAfter); err == nil {
+                *retryDeadAfter = d
+            }
+        }
+        if !*followRedirect && cfg.FollowRedirect {
+            *followRedirect = true
+        }
+        if *workerStats == 0 && cfg.WorkerStats != "" {
+            if d, err := time.ParseDuration(cfg.WorkerStats); err == nil {
+                *workerStats = d
+            }
+        }
+        if *services == "" && cfg.Services != "" {
+            *services = cfg.Services
+        }
+        if !*tfo && cfg.TFO {
+            *tfo = true
+        }
+        if *greetRetries == 1 && cfg.GreetRetries != 0 {
+            *greetRetries = cfg.GreetRetries
+        }
+        if *exportFormat == "" && cfg.ExportFormat != "" {
+            *exportFormat = cfg.ExportFormat
+        }
+        if *maxErrors == 0 && cfg.MaxErrors != 0 {
+            *maxErrors = cfg.MaxErrors
+        }
+        if *targetList == "" && cfg.Targets != "" {
+            *targetList = cfg.Targets
+        }
+        if *portList == "" && cfg.PortList != "" {
+            *portList = cfg.PortList
+        }
+        if !*fingerprint && cfg.Fingerprint {
+            *fingerprint = true
+        }
+        if *heartbeat == 30*time.Second && cfg.Heartbeat != "" {
+            if d, err := time.ParseDuration(cfg.Heartbeat); err == nil {
+                *heartbeat = d
+            }
+        }
+        if !*ipv4Only && cfg.IPv4Only {
+            *ipv4Only = true
+        }
+        if !*ipv6Only && cfg.IPv6Only {
+            *ipv6Only = true
+        }
+        if *seenDBPath == "" && cfg.SeenDB != "" {
+            *seenDBPath = cfg.SeenDB
+        }
+        if *seenTTL == 24*time.Hour && cfg.SeenTTL != "" {
+            if d, err := time.ParseDuration(cfg.SeenTTL); err == nil {
+                *seenTTL = d
+            }
+        }
+        if *appendJSONL == "" && cfg.OutputAppendJSONL != "" {
+            *appendJSONL = cfg.OutputAppendJSONL
+        }
+        if !*reportOpenTCP && cfg.ReportOpenTCP {
+            *reportOpenTCP = true
+        }
+        if !*reverse && cfg.Reverse {
+            *reverse = true
+        }
+        if *maxConnections == 0 && cfg.MaxConnections != 0 {
+            *maxConnections = cfg.MaxConnections
+        }
+        if !*judge && cfg.Judge {
+            *judge = true
+        }
+        if *taskBuffer == 0 && cfg.TaskBuffer != 0 {
+            *taskBuffer = cfg.TaskBuffer
+        }
+        if *resultBuffer == 100 && cfg.ResultBuffer != 0 {
+            *resultBuffer = cfg.ResultBuffer
+        }
+        if !*outputHash && cfg.OutputHash {
+            *outputHash = true
+        }
+        if !*socks4Ident && cfg.SOCKS4Ident {
+            *socks4Ident = true
+        }
+        if !*adaptiveDial && cfg.AdaptiveTimeout {
+            *adaptiveDial = true
+        }
+        if *adaptiveMin == 200*time.Millisecond && cfg.DialTimeoutMin != "" {
+            if d, err := time.ParseDuration(cfg.DialTimeoutMin); err == nil {
+                *adaptiveMin = d
+            }
+        }
+        if *adaptiveMax == 0 && cfg.DialTimeoutMax != "" {
+            if d, err := time.ParseDuration(cfg.DialTimeoutMax); err == nil {
+                *adaptiveMax = d
+            }
+        }
+        if *recordFailures == "" && cfg.RecordFailures != "" {
+            *recordFailures = cfg.RecordFailures
+        }
+        if !*checkBind && cfg.CheckBind {
+            *checkBind = true
+        }
+        if *limitPerProtocol == "" && cfg.LimitPerProtocol != "" {
+            *limitPerProtocol = cfg.LimitPerProtocol
+        }
+        if !*timeoutDistribution && cfg.TimeoutHistogram {
+            *timeoutDistribution = true
+        }
+        if *iface == "" && cfg.Interface != "" {
+            *iface = cfg.Interface
+        }
+        if !*skipWarmup && cfg.SkipWarmup {
+            *skipWarmup = true
+        }
+        if *runTag == "" && cfg.Tag != "" {
+            *runTag = cfg.Tag
+        }
+        if !*compressOutput && cfg.CompressOutput {
+            *compressOutput = true
+        }
+        if *maxMemory == 0 && cfg.MaxMemory != 0 {
+            *maxMemory = cfg.MaxMemory
+        }
+        if *realTarget == "" && cfg.RealTarget != "" {
+            *realTarget = cfg.RealTarget
+        }
+        if *credentialsFile == "" && cfg.CredentialsFile != "" {
+            *credentialsFile = cfg.CredentialsFile
+        }
+        if *maxPorts == 1000 && cfg.MaxPorts != 0 {
+            *maxPorts = cfg.MaxPorts
+        }
+        if *maxTasks == 10000000 && cfg.MaxTasks != 0 {
+            *maxTasks = cfg.MaxTasks
+        }
+        if !*force && cfg.Force {
+            *force = true
+        }
+        if *colorMode == "auto" && cfg.Color != "" {
+            *colorMode = cfg.Color
+        }
+        if !*reorder && cfg.AdaptiveOrder {
+            *reorder = true
+        }
+        if *writeInterval == time.Second && cfg.WriteInterval != "" {
+            if d, err := time.ParseDuration(cfg.WriteInterval); err == nil {
+                *writeInterval = d
+            }
+        }
+        if *sourcePortRange == "" && cfg.SourcePorts != "" {
+            *sourcePortRange = cfg.SourcePorts
+        }
+        if *minSuccessRate == "" && cfg.MinSuccessRate != "" {
+            *minSuccessRate = cfg.MinSuccessRate
+        }
+        if *minSuccessTries == 256 && cfg.MinSuccessTries != 0 {
+            *minSuccessTries = cfg.MinSuccessTries
+        }
+        if !*socksTLS && cfg.SOCKSTLS {
+            *socksTLS = true
+        }
+        if *refreshInterval == 60 && cfg.RefreshInterval != 0 {
+            *refreshInterval = cfg.RefreshInterval
+        }
+        if !*shuffle && cfg.Shuffle {
+            *shuffle = true
+        }
+        if *seed == 0 && cfg.Seed != 0 {
+            *seed = cfg.Seed
+        }
+        if *mode == "proxy" && cfg.Mode != "" {
+            *mode = cfg.Mode
+        }
+        if *minLatency == 0 && cfg.MinLatency != "" {
+            if d, err := time.ParseDuration(cfg.MinLatency); err == nil {
+                *minLatency = d
+            }
+        }
+        if *maxLatency == 0 && cfg.MaxLatency != "" {
+            if d, err := time.ParseDuration(cfg.MaxLatency); err == nil {
+                *maxLatency = d
+            }
+        }
+        if *customCheck == "" && cfg.CustomCheck != "" {
+            *customCheck = cfg.CustomCheck
+        }
+        if !*daemon && cfg.Daemon {
+            *daemon = true
+        }
+        if *apiAddr == "" && cfg.APIAddr != "" {
+            *apiAddr = cfg.APIAddr
+        }
+        if *apiToken == "" && cfg.APIToken != "" {
+            *apiToken = cfg.APIToken
+        }
+        if !*summaryFile && cfg.Summary {
+            *summaryFile = true
+        }
+        if *evictAfter == 3 && cfg.EvictAfter != 0 {
+            *evictAfter = cfg.EvictAfter
+        }
+        if *outputDir == "." && cfg.OutputDir != "" {
+            *outputDir = cfg.OutputDir
+        }
+        if *out == "" && cfg.Out != "" {
+            *out = cfg.Out
+        }
+        if *logLevel == "info" && cfg.LogLevel != "" {
+            *logLevel = cfg.LogLevel
+        }
+        if *backoffAfter == 0 && cfg.BackoffAfter != 0 {
+            *backoffAfter = cfg.BackoffAfter
+        }
+        if *backoffMax == 10 && cfg.BackoffMax != 0 {
+            *backoffMax = cfg.BackoffMax
+        }
+        if *through == "" && cfg.Through != "" {
+            *through = cfg.Through
+        }
+        if *testURLs == "http://www.google.com/" && cfg.TestURLs != "" {
+            *testURLs = cfg.TestURLs
+        }
+        if *testIPs == "142.250.74.68:80" && cfg.TestIPs != "" {
+            *testIPs = cfg.TestIPs
+        }
+        if !*resolveOnce && cfg.ResolveOnce {
+            *resolveOnce = true
+        }
+        if *dnsTTL == 300 && cfg.DNSTTL != 0 {
+            *dnsTTL = cfg.DNSTTL
+        }
+        if *protocolOrder == "http,socks4,socks5" && cfg.ProtocolOrder != "" {
+            *protocolOrder = cfg.ProtocolOrder
+        }
+        if *acceptStatus == "2xx" && cfg.AcceptStatus != "" {
+            *acceptStatus = cfg.AcceptStatus
+        }
+        if *httpVersion == "1.1" && cfg.HTTPVersion != "" {
+            *httpVersion = cfg.HTTPVersion
+        }
+        if *maxResponseBytes == 16384 && cfg.MaxResponseBytes != 0 {
+            *maxResponseBytes = cfg.MaxResponseBytes
+        }
+        if *asnDB == "" && cfg.ASNDB != "" {
+            *asnDB = cfg.ASNDB
+        }
+        if *includeASN == "" && cfg.IncludeASN != "" {
+            *includeASN = cfg.IncludeASN
+        }
+        if *excludeASN == "" && cfg.ExcludeASN != "" {
+            *excludeASN = cfg.ExcludeASN
+        }
+    }
+
+    // Logs go to stderr, so that is the terminal -color=auto looks at;
+    // proxy lines on stdout (-out -) are data and never colored
+    on, err := useColor(*colorMode, os.Stderr)
+    if err != nil {
+        log.Fatalf("Invalid -color %q: %v", *colorMode, err)
+    }
+    colorOn = on
+    if colorOn {
+        log.SetOutput(warnWriter{logOut})
+    }
+
+    if *dumpSchema {
+        if err := writeSchema(os.Stdout); err != nil {
+            log.Fatalf("Cannot print schema: %v", err)
+        }
+        os.Exit(0)
+    }
+    if *printConfig {
+        if err := writeEffectiveConfig(os.Stdout); err != nil {
+            log.Fatalf("Cannot print config: %v", err)
+        }
+        os.Exit(0)
+    }
+
+    stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+    if err != nil {
+        log.Fatalf("Cannot start profiling: %v", err)
+    }
+    defer stopProfiles()
+    // exit ends the run early with code, writing the profiles first
+    exit := func(code int) {
+        stopProfiles()
+        os.Exit(code)
+    }
+
+    acceptSet, err := parseStatusSet(*acceptStatus)
+    if err != nil {
+        log.Fatalf("Invalid -accept-status: %v", err)
+    }
+    order, err := parseProtocolOrder(*protocolOrder)
+    if err != nil {
+        log.Fatalf("Invalid -protocol-order: %v", err)
+    }
+    var limits *protocolLimits
+    if *limitPerProtocol != "" {
+        if limits, err = parseProtocolLimits(*limitPerProtocol); err != nil {
+            log.Fatalf("Invalid -limit-per-protocol %q: %v", *limitPerProtocol, err)
+        }
+    }
+    family := 0
+    switch {
+    case *ipv4Only && *ipv6Only:
+        log.Fatal("-ipv4-only and -ipv6-only are mutually exclusive")
+    case *ipv4Only:
+        family = 4
+    case *ipv6Only:
+        family = 6
+    }
+    if *replay != "" && *targetsJSONL != "" {
+        log.Fatal("-replay and -targets-jsonl are alternative inputs; use one")
+    }
+    if *reverse && *replay == "" {
+        log.Fatal("-reverse needs the proxy list to check in -replay")
+    }
+    if *reverse && *daemon {
+        log.Fatal("-reverse is a one-shot report and cannot run as -daemon")
+    }
+    if (*replay != "" || *targetsJSONL != "") && (*targetList != "" || *portList != "") {
+        log.Fatal("-targets and -port-list cannot be combined with -replay or -targets-jsonl")
+    }
+    if *maxOutputSize > 0 && *daemon {
+        log.Fatal("-max-output-size applies to streamed output; -daemon rewrites its file with only the live set")
+    }
+    if *httpsProxy {
+        order = append(order, "HTTPS-PROXY")
+    }
+    if *socksTLS {
+        order = append(order, "SOCKS5-TLS")
+    }
+    if *tlsVerify && *tlsSNI == "" {
+        log.Fatal("-tls-verify needs -tls-sni to verify against")
+    }
+    if *httpVersion != "1.0" && *httpVersion != "1.1" {
+        log.Fatalf("Invalid -http-version %q (want 1.0 or 1.1)", *httpVersion)
+    }
+    *httpMethod = strings.ToUpper(*httpMethod)
+    if *httpMethod != "GET" && *httpMethod != "HEAD" {
+        log.Fatalf("Invalid -http-method %q (want GET or HEAD)", *httpMethod)
+    }
+    exportFormats, err := parseExportFormats(*exportFormat)
+    if err != nil {
+        log.Fatalf("Invalid -export-format: %v", err)
+    }
+    if *noOutput && len(exportFormats) > 0 {
+        log.Fatal("-no-output cannot be combined with -export-format")
+    }
+    if enc, ok := lineEncodings[*outputEncoding]; ok {
+        outputLine = enc
+    } else {
+        log.Fatalf("Invalid -output-encoding %q (want plain, url or base64)", *outputEncoding)
+    }
+    if *mode != "proxy" && *mode != "portscan" {
+        log.Fatalf("Invalid -mode %q (want proxy or portscan)", *mode)
+    }
+    if *noOutput && (*daemon || *out != "") {
+        log.Fatal("-no-output cannot be combined with -daemon or -out")
+    }
+    enrichOpts, err := parseEnrich(*enrich)
+    if err != nil {
+        log.Fatalf("Invalid -enrich: %v", err)
+    }
+    if *bandwidthTest != "" {
+        pool, err := parseTestURLs(*bandwidthTest)
+        if err != nil {
+            log.Fatalf("Invalid -bandwidth-test: %v", err)
+        }
+        enrichOpts.Bandwidth, enrichOpts.BandwidthBytes = pool.pick(), *bandwidthBytes
+    }
+    httpTargets, err := parseTestURLs(*testURLs)
+    if err != nil {
+        log.Fatalf("Invalid -test-urls: %v", err)
+    }
+    socks4Targets, err := parseTestIPs(*testIPs)
+    if err != nil {
+        log.Fatalf("Invalid -test-ips: %v", err)
+    }
+    var proxyCreds credentials
+    if *credentialsFile != "" {
+        proxyCreds = readCredentialsFile(*credentialsFile)
+    }
+    var realHTTPTarget *testTarget
+    if *realTarget != "" {
+        pool, err := parseTestURLs(*realTarget)
+        if err != nil {
+            log.Fatalf("Invalid -real-target %q: %v", *realTarget, err)
+        }
+        realHTTPTarget = pool.pick()
+    }
+    scanner := &Scanner{
+        Timeout:          *timeout,
+        Dialer:           netDialer{},
+        Mode:             *mode,
+        GrabBanner:       *grabBannerBytes,
+        AcceptStatus:     acceptSet,
+        MaxResponseBytes: *maxResponseBytes,
+        HTTPVersion:      *httpVersion,
+        HTTPMethod:       *httpMethod,
+        HeadFallback:     *headFallback,
+        FollowRedirect:   *followRedirect,
+        GreetRetries:     *greetRetries,
+        Fingerprint:      *fingerprint,
+        Judge:            *judge,
+        CheckBind:        *checkBind,
+        Limits:           limits,
+        ReportOpenTCP:    *reportOpenTCP,
+        SOCKS4Ident:      *socks4Ident,
+        Order:            order,
+        HTTPTargets:      httpTargets,
+        SOCKS4Targets:    socks4Targets,
+        RealTarget:       realHTTPTarget,
+        Credentials:      proxyCreds,
+        DNS:              newDNSCache(time.Duration(*dnsTTL)*time.Second, family),
+        ResolveOnce:      *resolveOnce,
+        CustomCheck:      *customCheck,
+        FDs:              &fdGuard{},
+        TLS:              &tls.Config{ServerName: *tlsSNI, InsecureSkipVerify: !*tlsVerify},
+    }
+    if *resolveOnce {
+        if err := scanner.DNS.warm(httpTargets, socks4Targets); err != nil {
+            log.Fatalf("Cannot resolve test targets: %v", err)
+        }
+    }
+    // portscan mode only connects, using the pre-scan timeout when set
+    scanner.OpenTimeout = time.Duration(*timeout) * time.Second
+    if *connectTimeout > 0 {
+        scanner.OpenTimeout = time.Duration(*connectTimeout) * time.Millisecond
+    }
+    if *backoffAfter > 0 {
+        scanner.Backoff = newDialBackoff(*backoffAfter, time.Duration(*backoffMax)*time.Second)
+    }
+    if *adaptiveDial {
+        upper := *adaptiveMax
+        if upper == 0 {
+            upper = time.Duration(*timeout) * time.Second
+        }
+        if *adaptiveMin <= 0 || upper < *adaptiveMin {
+            log.Fatalf("Invalid -dial-timeout-min %s / -dial-timeout-max %s: want 0 < min <= max", *adaptiveMin, upper)
+        }
+        scanner.Adaptive = newAdaptiveTimeout(*adaptiveMin, upper)
+    }
+    if *reorder {
+        scanner.Reorder = newAdaptiveOrder(order)
+    }
+    direct := netDialer{tfo: *tfo}
+    if *dialFrom != "" {
+        addr, err := localAddr(*dialFrom)
+        if err != nil {
+            log.Fatalf("Invalid -dial-from %q: %v", *dialFrom, err)
+        }
+        direct.local = addr
+    }
+    if *sourcePortRange != "" {
+        sp, err := parseSourcePorts(*sourcePortRange)
+        if err != nil {
+            log.Fatalf("Invalid -source-ports %q: %v", *sourcePortRange, err)
+        }
+        direct.ports = sp
+    }
+    if *iface != "" {
+        if err := direct.pinInterface(*iface, family); err != nil {
+            log.Fatalf("Invalid -interface %q: %v", *iface, err)
+        }
+    }
+    scanner.Dialer = direct
+    scanner.TFO = *tfo && *through == ""
+    if *through != "" {
+        d, err := newUpstreamDialer(*through, *timeout, direct)
+        if err != nil {
+            log.Fatalf("Invalid -through: %v", err)
+        }
+        scanner.Dialer = d
+    }
+    if *maxConnections > 0 {
+        scanner.Dialer = newLimitDialer(scanner.Dialer, *maxConnections)
+    }
+
+    if *selfTest != "" {
+        if _, _, err := net.SplitHostPort(*selfTest); err != nil {
+            log.Fatalf("Invalid -self-test %q: want IP:PORT", *selfTest)
+        }
+        exit(runSelfTest(scanner, *selfTest, os.Stdout))
+    }
+    if *benchmark != "" {
+        if *benchmark != builtinBenchmark {
+            if _, _, err := net.SplitHostPort(*benchmark); err != nil {
+                log.Fatalf("Invalid -benchmark %q: want IP:PORT or builtin", *benchmark)
+            }
+        }
+        if *benchmarkDuration <= 0 {
+            log.Fatalf("Invalid -benchmark-duration %s: must be positive", *benchmarkDuration)
+        }
+        exit(runBenchmark(scanner, *benchmark, *workers, *benchmarkDuration, os.Stdout))
+    }
+
+    if *quietErrors {
+        skipWarn = func(string, ...interface{}) {}
+    }
+
+    excluded := make(map[int]bool)
+    if *excludePortList != "" {
+        ports, bad := parsePorts(strings.Split(*excludePortList, ","))
+        if len(bad) > 0 {
+            log.Fatalf("Invalid -exclude-ports entries: %s", bad.sample())
+        }
+        for _, port := range ports {
+            excluded[port] = true
+        }
+    }
+
+    jitter, err := parseJitter(*jitterFlag)
+    if err != nil {
+        log.Fatalf("Invalid -jitter %q: %v", *jitterFlag, err)
+    }
+    if *compressOutput && *maxOutputSize > 0 {
+        log.Fatal("-compress-output cannot be combined with -max-output-size")
+    }
+    if *taskBuffer < 0 {
+        log.Fatalf("Invalid -task-buffer %d: must not be negative", *taskBuffer)
+    }
+    if *resultBuffer < 0 {
+        log.Fatalf("Invalid -result-buffer %d: must not be negative", *resultBuffer)
+    }
+    if *writeInterval < 0 {
+        log.Fatalf("Invalid -write-interval %s: must not be negative", *writeInterval)
+    }
+    var successRate float64
+    if *minSuccessRate != "" {
+        successRate, err = strconv.ParseFloat(strings.TrimSuffix(*minSuccessRate, "%"), 64)
+        if err != nil || successRate <= 0 || successRate > 100 {
+            log.Fatalf("Invalid -min-success-rate %q: want a percentage above 0 and up to 100, e.g. 0.5%%", *minSuccessRate)
+        }
+    }
+    if *minSuccessTries < 1 {
+        log.Fatalf("Invalid -min-success-attempts %d: must be positive", *minSuccessTries)
+    }
+    if *maxPorts < 0 {
+        log.Fatalf("Invalid -max-ports %d: must not be negative", *maxPorts)
+    }
+    if *maxTasks < 0 {
+        log.Fatalf("Invalid -max-tasks %d: must not be negative", *maxTasks)
+    }
+    if *taskBuffer == 0 {
+        *taskBuffer = *workers * 2
+    }
+
+    if *validate {
+        exit(runValidate(validateSources{
+            CIDRFile:  *cidrFile,
+            PortsFile: *portsFile,
+            Targets:   *targetList,
+            PortList:  *portList,
+            Services:  *services,
+        }, os.Stdout))
+    }
+
+    var svcPorts []int
+    if *services != "" {
+        svcPorts, err = servicePorts(*services)
+        if err != nil {
+            log.Fatalf("Invalid -services: %v", err)
+        }
+    }
+
+    // -reverse judges its candidates by what connects back, not through
+    // the test targets, so there is nothing to warm up
+    if !*skipWarmup && *mode != "portscan" && !*reverse {
+        if err := scanner.warmup(); err != nil {
+            log.Printf("Warmup failed: %v", err)
+            log.Printf("The test targets must be reachable from here for any proxy to pass; fix -test-urls / -test-ips or use -skip-warmup")
+            exit(exitNoConnectivity)
+        }
+        logPrint("debug", *logLevel, "[*] Warmup: test targets reachable\n")
+    }
+
+    // Fetched input lists are cached across runs, so they stay in the
+    // base output directory even with -timestamped
+    cacheDir := *outputDir
+    if *timestamped {
+        *outputDir = filepath.Join(*outputDir, time.Now().Format("20060102-150405"))
+        if err := os.MkdirAll(*outputDir, os.ModePerm); err != nil {
+            log.Fatalf("Cannot create run directory: %v", err)
+        }
+        logPrint("info", *logLevel, "[*] Writing run output to %s\n", *outputDir)
+    }
+
+    // Check that the outputs can be written before the inputs are read
+    // and expanded, so a permissions problem fails in the first second
+    // rather than after the setup work
+    var outDirs []string
+    if *out != "" && *out != "-" {
+        outDirs = append(outDirs, filepath.Dir(*out))
+    }
+    if (*out == "" && !*noOutput) || *summaryFile || len(exportFormats) > 0 {
+        outDirs = append(outDirs, *outputDir)
+    }
+    for _, dir := range outDirs {
+        if err := checkWritable(dir); err != nil {
+            log.Fatalf("Output directory %s is not writable: %v", dir, err)
+        }
+    }
+
+    // Targets come from -cidr × -ports, or as a ready task list: with
+    // -replay from the addresses in an earlier results file, with
+    // -targets-jsonl from tagged entries
+    var allIPs []string
+    var portsToScan []int
+    var taskList []Task
+    var cidrOf map[string]string // IP → input CIDR, with -per-cidr-concurrency
+    reloadTargets := func() {}
+    if *replay != "" || *targetsJSONL != "" {
+        if *replay != "" {
+            taskList = readReplayFile(*replay)
+            logPrint("info", *logLevel, "[*] Replaying %d addresses from %s\n", len(taskList), *replay)
+        } else {
+            var creds credentials
+            limit := *maxTasks
+            if *force {
+                limit = 0
+            }
+            taskList, creds = readTargetsJSONL(*targetsJSONL, limit)
+            if len(creds) > 0 && scanner.Credentials == nil {
+                scanner.Credentials = make(credentials)
+            }
+            for address, cred := range creds {
+                scanner.Credentials[address] = cred
+            }
+            logPrint("info", *logLevel, "[*] Read %d targets from %s\n", len(taskList), *targetsJSONL)
+        }
+        if len(excluded) > 0 {
+            kept := taskList[:0]
+            for _, t := range taskList {
+                if !excluded[t.Port] {
+                    kept = append(kept, t)
+                }
+            }
+            taskList = kept
+            if len(taskList) == 0 {
+                log.Fatal("No addresses left after -exclude-ports")
+            }
+        }
+        if family != 0 {
+            kept := taskList[:0]
+            for _, t := range taskList {
+                if ip := net.ParseIP(t.IP); ip != nil && ipFamily(ip) == family {
+                    kept = append(kept, t)
+                }
+            }
+            logPrint("info", *logLevel, "[*] -ipv%d-only dropped %d of %d addresses\n", family, len(taskList)-len(kept), len(taskList))
+            taskList = kept
+            if len(taskList) == 0 {
+                log.Fatalf("No IPv%d addresses left after -ipv%d-only", family, family)
+            }
+        }
+        seen := make(map[string]bool)
+        for _, t := range taskList {
+            if !seen[t.IP] {
+                seen[t.IP] = true
+                allIPs = append(allIPs, t.IP)
+            }
+        }
+    } else {
+        // --- Read CIDRs from -cidr and -targets; -targets alone replaces
+        // the default Cidr.txt ---
+        cidrCache := filepath.Join(cacheDir, "cidr.cache")
+        inlineTargets := splitList(*targetList)
+        cidrSource := "-targets"
+        var cidrList []string
+        if *targetList == "" || *cidrFile != "Cidr.txt" {
+            cidrList = readInputFile(*cidrFile, "one CIDR, start-end IP range or IP per line", cidrCache)
+            cidrSource = *cidrFile
+        }
+        cidrList = append(cidrList, inlineTargets...)
+
+        // --- Read Ports from -ports and -port-list; -port-list alone
+        // replaces the default Ports.txt, and with -services a missing
+        // default Ports.txt is not an error ---
+        portsCache := filepath.Join(cacheDir, "ports.cache")
+        inlinePorts := splitList(*portList)
+        portsSource := "-port-list"
+        var portRanges []string
+        if _, err := os.Stat(*portsFile); *portsFile != "Ports.txt" || (*portList == "" && (*services == "" || err == nil)) {
+            portRanges = readInputFile(*portsFile, "one port or start-end port range per line", portsCache)
+            portsSource = *portsFile
+        }
+        portRanges = append(portRanges, inlinePorts...)
+
+        // --- Parse all port ranges ---
+        var badPorts invalidLines
+        portsToScan, badPorts = parsePorts(portRanges)
+        if len(portRanges) > 0 && len(portsToScan) == 0 {
+            inputFatal(exitInputInvalid, "No valid ports in %s: all %d lines are invalid (%s); expected ports like 8080 or ranges like 1080-1085", portsSource, len(badPorts), badPorts.sample())
+        }
+
+        if *quietErrors && len(badPorts) > 0 {
+            logPrint("debug", *logLevel, "[*] Skipped %d invalid lines in %s\n", len(badPorts), portsSource)
+        }
+        portsToScan = mergePorts(portsToScan, svcPorts)
+
+        if len(excluded) > 0 {
+            before := len(portsToScan)
+            portsToScan = excludePorts(portsToScan, excluded)
+            logPrint("info", *logLevel, "[*] -exclude-ports removed %d of %d ports\n", before-len(portsToScan), before)
+            if len(portsToScan) == 0 {
+                log.Fatal("No ports left after -exclude-ports")
+            }
+        }
+
+        // -max-tasks is checked against the prefix sizes first: 0.0.0.0/0
+        // or an IPv6 /64 would exhaust memory while being expanded. The
+        // count is before -ipv4-only/-ipv6-only and ASN filtering.
+        if *maxTasks > 0 && !*force {
+            ips := new(big.Int)
+            for _, line := range cidrList {
+                ips.Add(ips, targetSize(line))
+            }
+            tasks := new(big.Int).Mul(ips, big.NewInt(int64(len(portsToScan))))
+            if tasks.Cmp(big.NewInt(int64(*maxTasks))) > 0 {
+                log.Fatalf("%s tasks (%s IPs × %d ports) exceed -max-tasks %d; narrow the targets or ports, or pass -force", tasks, ips, len(portsToScan), *maxTasks)
+            }
+        }
+
+        // --- Expand all CIDRs to IPs ---
+        var badCIDRs invalidLines
+        allIPs, badCIDRs = expandTargets(cidrList)
+        if len(allIPs) == 0 {
+            inputFatal(exitInputInvalid, "No valid IPs in %s: all %d lines are invalid (%s); expected CIDRs like 10.0.0.0/24, ranges like 10.0.0.1-10.0.0.50, or IPs", cidrSource, len(badCIDRs), badCIDRs.sample())
+        }
+
+        if *perCIDR > 0 || successRate > 0 {
+            cidrOf = cidrIndex(cidrList)
+        }
+
+        if *quietErrors && len(badCIDRs) > 0 {
+            logPrint("debug", *logLevel, "[*] Skipped %d invalid lines in %s\n", len(badCIDRs), cidrSource)
+        }
+
+        // --- Filter IPs by address family ---
+        if family != 0 {
+            before := len(allIPs)
+            allIPs = filterFamily(allIPs, family)
+            logPrint("info", *logLevel, "[*] -ipv%d-only dropped %d of %d IPs\n", family, before-len(allIPs), before)
+            if len(allIPs) == 0 {
+                log.Fatalf("No IPv%d addresses left after -ipv%d-only", family, family)
+            }
+        }
+
+        // --- Filter IPs by ASN ---
+        filterIPs := func(ips []string) []string { return ips }
+        if *includeASN != "" || *excludeASN != "" {
+            if *asnDB == "" {
+                log.Fatal("-include-asn/-exclude-asn require -asn-db")
+            }
+            table, err := loadASNTable(*asnDB)
+            if err != nil {
+                log.Fatalf("Error reading ASN table: %v", err)
+            }
+            include, err := parseASNList(*includeASN)
+            if err != nil {
+                log.Fatalf("Invalid -include-asn: %v", err)
+            }
+            exclude, err := parseASNList(*excludeASN)
+            if err != nil {
+                log.Fatalf("Invalid -exclude-asn: %v", err)
+            }
+            filterIPs = func(ips []string) []string { return filterByASN(ips, table, include, exclude) }
+            before := len(allIPs)
+            allIPs = filterIPs(allIPs)
+            logPrint("info", *logLevel, "[*] ASN filter kept %d of %d IPs\n", len(allIPs), before)
+            if len(allIPs) == 0 {
+                log.Fatal("No IPs left after ASN filtering")
+            }
+        }
+
+        // reloadTargets re-fetches URL inputs between daemon passes so newly
+        // published ranges are picked up. A failed fetch or a list with no
+        // usable lines keeps the previous targets.
+        reloadTargets = func() {
+            if isURL(*cidrFile) && cidrSource == *cidrFile {
+                lines, err := fetchInput(*cidrFile, cidrCache, cleanLine)
+                lines = append(lines, inlineTargets...)
+                if err != nil {
+                    log.Printf("Cannot re-fetch %s: %v; keeping previous targets", *cidrFile, err)
+                } else if ips, _ := expandTargets(lines); len(filterIPs(filterFamily(ips, family))) > 0 {
+                    allIPs = filterIPs(filterFamily(ips, family))
+                    if *perCIDR > 0 || successRate > 0 {
+                        cidrOf = cidrIndex(lines)
+                    }
+                }
+            }
+            if isURL(*portsFile) && portsSource == *portsFile {
+                lines, err := fetchInput(*portsFile, portsCache, cleanLine)
+                lines = append(lines, inlinePorts...)
+                if err != nil {
+                    log.Printf("Cannot re-fetch %s: %v; keeping previous ports", *portsFile, err)
+                } else if ports, _ := parsePorts(lines); len(excludePorts(ports, excluded)) > 0 {
+                    portsToScan = excludePorts(mergePorts(ports, svcPorts), excluded)
+                }
+            }
+        }
+    }
+
+    // --- Size guards: a careless range such as 1-65535 over many IPs
+    // would otherwise start a multi-day scan ---
+    portCount, total := len(portsToScan), len(allIPs)*len(portsToScan)
+    if taskList != nil {
+        ports := make(map[int]bool)
+        for _, t := range taskList {
+            ports[t.Port] = true
+        }
+        portCount, total = len(ports), len(taskList)
+    }
+    logPrint("info", *logLevel, "[*] %d tasks: %d IPs × %d ports\n", total, len(allIPs), portCount)
+    if *maxPorts > 0 && portCount > *maxPorts {
+        if !*force {
+            log.Fatalf("%d ports exceed -max-ports %d; check the port ranges for a typo, or pass -force", portCount, *maxPorts)
+        }
+        log.Printf("%d ports exceed -max-ports %d; scanning anyway with -force", portCount, *maxPorts)
+    }
+    if *maxTasks > 0 && total > *maxTasks {
+        if !*force {
+            log.Fatalf("%d tasks exceed -max-tasks %d; narrow the targets or ports, or pass -force", total, *maxTasks)
+        }
+        log.Printf("%d tasks exceed -max-tasks %d; scanning anyway with -force", total, *maxTasks)
+    }
+
+    // --- Reachability matrix ---
+    if *reverse {
+        matrix := os.Stdout
+        path := *out
+        if path == "" {
+            if err := os.MkdirAll(*outputDir, os.ModePerm); err != nil {
+                log.Fatalf("Cannot create output directory: %v", err)
+            }
+            path = filepath.Join(*outputDir, "reachability.csv")
+        }
+        if path != "-" {
+            f, err := os.Create(path)
+            if err != nil {
+                log.Fatalf("Cannot create output file: %v", err)
+            }
+            matrix = f
+        }
+        status := runReverse(scanner, taskList, httpTargets.targets, *workers, matrix, *logLevel)
+        matrix.Close()
+        exit(status)
+    }
+
+    // --- Seen database ---
+    var seen *seenDB
+    if *seenDBPath != "" {
+        seen, err = loadSeenDB(*seenDBPath, *seenTTL)
+        if err != nil {
+            log.Fatalf("Cannot read -seen-db: %v", err)
+        }
+        logPrint("debug", *logLevel, "[*] Loaded %d -seen-db entries from %s\n", len(seen.entries), *seenDBPath)
+    }
+    saveSeen := func() {
+        if err := seen.save(); err != nil {
+            log.Printf("Cannot write -seen-db: %v", err)
+        }
+    }
+    if seen != nil {
+        onInterrupt(saveSeen)
+    }
+
+    var memGuard *memoryGuard
+    if *maxMemory > 0 {
+        memGuard = newMemoryGuard(*maxMemory)
+    }
+
+    var failures *failureLog
+    if *recordFailures != "" {
+        failures, err = openFailureLog(*recordFailures, *resultBuffer)
+        if err != nil {
+            log.Fatalf("Cannot open -record-failures: %v", err)
+        }
+    }
+
+    // --- Scanning ---
+
+    // -shuffle uses its own seeded source so a run can be replayed with -seed
+    var rng *rand.Rand
+    if *shuffle {
+        if *seed == 0 {
+            *seed = time.Now().UnixNano()
+            logPrint("info", *logLevel, "[*] Shuffle seed %d (pass -seed=%d to replay this order)\n", *seed, *seed)
+        }
+        rng = rand.New(rand.NewSource(*seed))
+    }
+
+    // taskCount and taskAt index the pass: the task list, or
+    // allIPs × portsToScan in IP-major order
+    taskCount := func() int {
+        if taskList != nil {
+            return len(taskList)
+        }
+        return len(allIPs) * len(portsToScan)
+    }
+    taskAt := func(i int) Task {
+        if taskList != nil {
+            return taskList[i]
+        }
+        ip := allIPs[i/len(portsToScan)]
+        return Task{IP: ip, Port: portsToScan[i%len(portsToScan)], CIDR: cidrOf[ip]}
+    }
+
+    // scan runs one full pass over the tasks, calling emit (from worker
+    // goroutines) with each result. Latencies of detected proxies
+    // accumulate in stats.
+    stats := newScanStats()
+
+    // -worker-stats gives each worker a status slot; its dials go through
+    // a per-worker copy of the scanner that keeps the slot current
+    var statuses []workerStatus
+    if *workerStats > 0 {
+        statuses = make([]workerStatus, *workers)
+        go reportWorkers(statuses, *workerStats, *logLevel)
+    }
+
+    // -max-errors probes the first HTTP test target as the control host
+    var errLimit *errorLimit
+    if *maxErrors > 0 {
+        control := httpTargets.targets[0]
+        controlAddr := net.JoinHostPort(control.Host, strconv.Itoa(control.Port))
+        errLimit = &errorLimit{
+            max:       int64(*maxErrors),
+            reachable: func() bool { return scanner.isOpen(controlAddr, time.Duration(*timeout)*time.Second) },
+        }
+    }
+
+    // inBand applies -min-latency and -max-latency to a found proxy
+    inBand := func(p Proxy) bool {
+        return (*minLatency <= 0 || p.Latency >= *minLatency) && (*maxLatency <= 0 || p.Latency <= *maxLatency)
+    }
+
+    scan := func(emit func(p Proxy)) {
+        n := taskCount()
+        stats.beginPass(n)
+        if *heartbeat > 0 {
+            done := make(chan struct{})
+            defer close(done)
+            go stats.heartbeat(*heartbeat, *logLevel, done)
+        }
+        tasks := make(chan Task, *taskBuffer)
+        var scanWg sync.WaitGroup
+
+        // -first-match-per-host and -max-host-time keep per-IP state, and
+        // -min-success-rate per-CIDR state, that workers check before
+        // dialing. The maps are filled up front so workers only read them.
+        var hosts map[string]*hostState
+        if *firstMatch || *maxHostTime > 0 {
+            hosts = make(map[string]*hostState, len(allIPs))
+            for _, ip := range allIPs {
+                hosts[ip] = &hostState{}
+            }
+        }
+        var gates cidrGates
+        if *perCIDR > 0 {
+            gates = newCIDRGates(cidrOf, *perCIDR)
+        }
+        var rates *cidrRates
+        if successRate > 0 {
+            rates = newCIDRRates(cidrOf, successRate, *minSuccessTries)
+        }
+
+        skip := func(task Task) bool {
+            if errLimit.aborted() || rates.abandoned(task.CIDR) {
+                return true
+            }
+            return hosts != nil && (hosts[task.IP].matched.Load() || hosts[task.IP].abandoned.Load())
+        }
+        // charge adds time spent on ip and abandons the host once it has
+        // used up -max-host-time
+        charge := func(ip string, d time.Duration) {
+            if *maxHostTime <= 0 {
+                return
+            }
+            h := hosts[ip]
+            if time.Duration(h.spent.Add(int64(d))) >= *maxHostTime && !h.abandoned.Swap(true) {
+                log.Printf("Abandoning %s: spent over %s across its ports", ip, *maxHostTime)
+            }
+        }
+
+        // With -connect-timeout, a fast TCP connect stage weeds out closed and
+        // filtered ports so the protocol checks only run on open ones. Tasks
+        // are counted by whichever stage takes them off the queue first.
+        checkTasks := tasks
+        prescan := *connectTimeout > 0 && *mode != "portscan"
+        if prescan {
+            checkTasks = make(chan Task, *taskBuffer)
+            var preWg sync.WaitGroup
+            for i := 0; i < *workers; i++ {
+                preWg.Add(1)
+                go func(rng *rand.Rand) {
+                    defer preWg.Done()
+                    for task := range tasks {
+                        stats.task()
+                        if skip(task) {
+                            continue
+                        }
+                        jitter.sleep(rng)
+                        release := gates.acquire(task.CIDR)
+                        start := time.Now()
+                        open := scanner.isOpen(task.Address(), time.Duration(*connectTimeout)*time.Millisecond)
+                        charge(task.IP, time.Since(start))
+                        release()
+                        errLimit.observe(!open)
+                        if open {
+                            checkTasks <- task
+                        } else {
+                            rates.observe(task.CIDR, false)
+                            seen.record(task.Address(), nil)
+                            failures.record(task.Address(), "closed")
+                        }
+                    }
+                }(workerRand(i))
+            }
+            go func() {
+                preWg.Wait()
+                close(checkTasks)
+            }()
+        }
+
+        for i := 0; i < *workers; i++ {
+            scanWg.Add(1)
+            ws := scanner
+            var status *workerStatus
+            if statuses != nil {
+                status = &statuses[i]
+                ws = scanner.withStatus(status)
+            }
+            go func(rng *rand.Rand) {
+                defer scanWg.Done()
+                for task := range checkTasks {
+                    logPrint("debug", *logLevel, "[*] Testing %s\n", task.Address())
+
+                    if !prescan {
+                        stats.task()
+                    }
+                    if skip(task) || limits.done() {
+                        continue
+                    }
+                    jitter.sleep(rng)
+                    release := gates.acquire(task.CIDR)
+                    start := time.Now()
+                    res := ws.scanTask(task)
+                    if status != nil {
+                        status.set(workerIdle, "")
+                    }
+                    charge(task.IP, time.Since(start))
+                    release()
+                    errLimit.observe(res.unreachable())
+                    rates.observe(task.CIDR, res.Err == nil && res.Proxy.AuthRequired == "")
+                    for _, a := range res.Attempts {
+                        stats.fail(a.Protocol, a.Err)
+                        logPrint("debug", *logLevel, "[-] %s %s: %v\n", task.Address(), a.Protocol, a.Err)
+                    }
+                    if res.Err != nil {
+                        seen.record(task.Address(), nil)
+                        failures.record(task.Address(), failureReason(res))
+                        continue
+                    }
+                    p := res.Proxy
+                    if *firstMatch && hosts[task.IP].matched.Swap(true) {
+                        continue // another port of this host won the race
+                    }
+                    switch p.Protocol {
+                    case "OPEN":
+                        stats.record("OPEN", p.Latency)
+                        logPrint("info", *logLevel, "%s %s open\n", colorize(ansiGreen, "[+]"), p.Address)
+                    case "BANNER":
+                        logPrint("info", *logLevel, "[~] %s banner: %q\n", p.Address, p.Banner)
+                    case "OPEN-TCP":
+                        logPrint("info", *logLevel, "[~] %s accepts connections but speaks no known proxy protocol\n", p.Address)
+                    default:
+                        if p.AuthRequired != "" {
+                            // Recorded, but neither found nor holding a
+                            // -limit-per-protocol slot
+                            stats.recordAuth(p.Protocol)
+                            logPrint("info", *logLevel, "[~] %s → %s (%s required)\n", p.Address, p.Protocol, p.AuthRequired)
+                            break
+                        }
+                        stats.record(p.Protocol, p.Latency)
+                        if !inBand(p) {
+                            logPrint("debug", *logLevel, "[-] %s → %s (%dms) outside latency band, not written\n", p.Address, p.Protocol, p.Latency.Milliseconds())
+                            continue
+                        }
+                        if !limits.add(p.Protocol) {
+                            logPrint("debug", *logLevel, "[-] %s → %s over -limit-per-protocol, not written\n", p.Address, p.Protocol)
+                            continue
+                        }
+                        logPrint("info", *logLevel, "%s %s → %s (%dms)\n", colorize(ansiGreen, "[+]"), p.Address, colorize(protocolColor(p.Protocol), p.Protocol), p.Latency.Milliseconds())
+                    }
+                    seen.record(task.Address(), &p)
+                    emit(p)
+                }
+            }(workerRand(i))
+        }
+
+        // Send all tasks, in a seeded random order with -shuffle. With
+        // -seen-db, addresses checked within -seen-ttl are not sent; the
+        // proxies among them are emitted again from the database.
+        skipped := 0
+        send := func(t Task) {
+            if limits.done() || errLimit.aborted() {
+                return
+            }
+            if e, ok := seen.fresh(t.Address()); ok {
+                skipped++
+                if e.Protocol != "" {
+                    stats.record(e.Protocol, time.Duration(e.LatencyMs)*time.Millisecond)
+                    emit(Proxy{Address: t.Address(), Protocol: e.Protocol, Latency: time.Duration(e.LatencyMs) * time.Millisecond, Tags: t.Tags})
+                }
+                return
+            }
+            memGuard.wait()
+            tasks <- t
+        }
+        if rng != nil {
+            for _, i := range rng.Perm(n) {
+                send(taskAt(i))
+            }
+        } else {
+            for i := 0; i < n; i++ {
+                send(taskAt(i))
+            }
+        }
+        close(tasks)
+        scanWg.Wait()
+        failures.sync()
+        if limits.done() {
+            logPrint("info", *logLevel, "[*] -limit-per-protocol met (%s), stopped early\n", limits)
+        }
+        if skipped > 0 {
+            logPrint("info", *logLevel, "[*] -seen-db skipped %d addresses checked within %s\n", skipped, *seenTTL)
+        }
+    }
+
+    // With -enrich, found proxies detour through a lookup pool that is
+    // drained at the end of each pass
+    if enrichOpts.enabled() {
+        plainScan := scan
+        scan = func(emit func(p Proxy)) {
+            e := newEnricher(scanner, enrichOpts, *workers, time.Duration(*timeout)*time.Second, emit)
+            plainScan(e.submit)
+            e.close()
+        }
+    }
+
+    // Every find is stamped with the -tag, or a run ID made up of the
+    // start time and a random suffix, for provenance in merged datasets
+    run := *runTag
+    if run == "" {
+        run = newRunID(time.Now())
+    } else {
+        textRun = true
+    }
+    logPrint("debug", *logLevel, "[*] Run %s\n", run)
+    taggedScan := scan
+    scan = func(emit func(p Proxy)) {
+        taggedScan(func(p Proxy) {
+            p.Run = run
+            emit(p)
+        })
+    }
+
+    // -output-append-jsonl records every find, after enrichment, as a
+    // versioned event
+    if *appendJSONL != "" {
+        events, err := openEventLog(*appendJSONL)
+        if err != nil {
+            log.Fatalf("Cannot open -output-append-jsonl: %v", err)
+        }
+        innerScan := scan
+        scan = func(emit func(p Proxy)) {
+            innerScan(func(p Proxy) {
+                if err := events.write(p); err != nil {
+                    log.Printf("Cannot write -output-append-jsonl: %v", err)
+                }
+                emit(p)
+            })
+        }
+    }
+
+    // --- Summary ---
+    printSummary := func(sum Summary) {
+        sum.TFO = *tfo
+        sum.Run = run
+        if !*timeoutDistribution {
+            sum.Histogram = nil
+        }
+        logPrint("info", *logLevel, "%s", sum)
+        if *summaryFile {
+            summaryPath := *outputDir + string(os.PathSeparator) + "summary.json"
+            if err := writeSummary(summaryPath, sum); err != nil {
+                log.Printf("Cannot write summary: %v", err)
+            }
+        }
+    }
+
+    // --- Export configs ---
+    export := func(store *resultStore) {
+        if len(exportFormats) == 0 {
+            return
+        }
+        if err := os.MkdirAll(*outputDir, os.ModePerm); err != nil {
+            log.Printf("Cannot write -export-format output: %v", err)
+            return
+        }
+        if err := writeExports(exportFormats, *outputDir, store.snapshot()); err != nil {
+            log.Printf("Cannot write -export-format output: %v", err)
+        }
+    }
+
+    // --- Prepare output file ---
+    urlCredentials = scanner.Credentials
+    outPath := *out
+    if *noOutput {
+        outPath = "-"
+    }
+    if outPath == "" {
+        if err := os.MkdirAll(*outputDir, os.ModePerm); err != nil {
+            log.Fatalf("Cannot create output directory: %v", err)
+        }
+        outPath = *outputDir + string(os.PathSeparator) + "proxies.txt"
+        if *compressOutput {
+            outPath += ".gz"
+        }
+    }
+
+    // --- Control API ---
+    store := newResultStore()
+    var refreshNow chan struct{}
+    if *daemon {
+        refreshNow = make(chan struct{}, 1)
+    }
+    if *apiAddr != "" {
+        api := &apiServer{stats: stats, store: store, refresh: refreshNow, token: *apiToken}
+        if err := startAPI(*apiAddr, api); err != nil {
+            log.Fatalf("Cannot start API on %s: %v", *apiAddr, err)
+        }
+        logPrint("info", *logLevel, "[*] Control API listening on %s\n", *apiAddr)
+    }
+
+    if *daemon {
+        if outPath == "-" {
+            log.Fatal("-daemon needs a file output, not -out -")
+        }
+        passes := 0
+        daemonScan := func(emit func(p Proxy)) {
+            if passes > 0 {
+                reloadTargets()
+            }
+            passes++
+            scan(emit)
+            export(store)
+            saveSeen()
+        }
+        // Stored records are re-tested the way they were found: through
+        // scanTask, in portscan mode for open ports, and held to the
+        // latency band; a record whose protocol changed needs a free
+        // -limit-per-protocol slot
+        retest := func(r Record) (Proxy, bool) {
+            host, port, _ := net.SplitHostPort(r.Address)
+            portNum, _ := strconv.Atoi(port)
+            c := scanner
+            if r.Protocol == "OPEN" && scanner.Mode != "portscan" {
+                pc := *scanner
+                pc.Mode = "portscan"
+                c = &pc
+            }
+            res := c.scanTask(Task{IP: host, Port: portNum, Tags: r.Tags})
+            if res.Err != nil {
+                return Proxy{}, false
+            }
+            p := res.Proxy
+            switch {
+            case p.Protocol == "OPEN", p.Protocol == "BANNER", p.Protocol == "OPEN-TCP", p.AuthRequired != "":
+            default:
+                if !inBand(p) || (p.Protocol != r.Protocol && !limits.add(p.Protocol)) {
+                    return Proxy{}, false
+                }
+            }
+            return p, true
+        }
+        runDaemon(retest, daemonScan, errLimit.aborted, store, refreshNow, outPath, *compressOutput, *outputHash, *refreshInterval, *evictAfter, *retryDeadAfter, *workers, *logLevel)
+        exit(exitNoConnectivity)
+    }
+
+    // -no-output only counts: no file, no writer goroutine
+    if *noOutput {
+        scan(func(p Proxy) { store.upsert(p) })
+        saveSeen()
+        printSummary(stats.summary())
+        if errLimit.aborted() {
+            exit(exitNoConnectivity)
+        }
+        return
+    }
+
+    // "-" streams results to stdout; logs stay on stderr so the two never mix.
+    var output io.Writer = os.Stdout
+    if outPath != "-" {
+        outFile, err := newRotatingFile(outPath, *maxOutputSize, *outputRotations)
+        if err != nil {
+            log.Fatalf("Cannot create output file: %v", err)
+        }
+        defer outFile.Close()
+        output = outFile
+    }
+    // -compress-output gzips the stream; the trailer is written once the
+    // scan is done, or on interrupt, so the file is always a valid archive
+    var zw *gzipWriter
+    if *compressOutput {
+        zw = newGzipWriter(output)
+        output = zw
+    }
+
+    // Lines are buffered and flushed every -write-interval (0 flushes each
+    // line), once more when the scan is done and on interrupt, before the
+    // gzip trailer
+    var writeMu sync.Mutex
+    writer := bufio.NewWriter(output)
+    flush := func() {
+        writeMu.Lock()
+        defer writeMu.Unlock()
+        if err := writer.Flush(); err != nil {
+            log.Printf("Cannot write output: %v", err)
+        }
+    }
+    onInterrupt(flush)
+    if zw != nil {
+        onInterrupt(func() { zw.Close() })
+    }
+
+    // Only addresses new to the store reach the writer, so each is
+    // written once
+    foundChan := make(chan Proxy, *resultBuffer)
+    var writerWg sync.WaitGroup
+    writerWg.Add(1)
+    go func() {
+        defer writerWg.Done()
+        var tick <-chan time.Time
+        if *writeInterval > 0 {
+            ticker := time.NewTicker(*writeInterval)
+            defer ticker.Stop()
+            tick = ticker.C
+        }
+        for {
+            select {
+            case p, ok := <-foundChan:
+                if !ok {
+                    flush()
+                    return
+                }
+                writeMu.Lock()
+                writer.WriteString(outputLine(p) + "\n")
+                writeMu.Unlock()
+                if *writeInterval == 0 {
+                    flush()
+                }
+            case <-tick:
+                flush()
+            }
+        }
+    }()
+
+    scan(func(p Proxy) {
+        if store.upsert(p) {
+            foundChan <- p
+        }
+    })
+    close(foundChan)
+    writerWg.Wait()
+    if zw != nil {
+        if err := zw.Close(); err != nil {
+            log.Printf("Cannot write output: %v", err)
+        }
+    }
+    export(store)
+    saveSeen()
+
+    sum := stats.summary()
+    if *outputHash && outPath != "-" {
+        if sum.OutputHash, err = writeChecksum(outPath); err != nil {
+            log.Printf("Cannot write %s.sha256: %v", outPath, err)
+        }
+    }
+    printSummary(sum)
+    if errLimit.aborted() {
+        exit(exitNoConnectivity)
+    }
+}
+
+// readLines reads all lines from a text file into a string slice, dropping
+// blank lines and "#" comments (whole-line or trailing)
+func readLines(filename string) ([]string, error) {
+    return readLinesWith(filename, cleanLine)
+}
+
+// readLinesWith is readLines with clean in place of cleanLine; a line
+// clean returns "" for is dropped
+func readLinesWith(filename string, clean func(string) string) ([]string, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return parseLinesWith(file, clean)
+}
+
+// parseLines is readLines for any reader. Gzipped input is decompressed.
+func parseLines(r io.Reader) ([]string, error) {
+    return parseLinesWith(r, cleanLine)
+}
+
+// parseLinesWith is readLinesWith for any reader
+func parseLinesWith(r io.Reader, clean func(string) string) ([]string, error) {
+    r, err := maybeGunzip(r)
+    if err != nil {
+        return nil, err
+    }
+    var lines []string
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        if line := clean(scanner.Text()); line != "" {
+            lines = append(lines, line)
+        }
+    }
+    return lines, scanner.Err()
+}
+
+// cleanLine strips a "#" comment and surrounding whitespace
+func cleanLine(line string) string {
+    if i := strings.IndexByte(line, '#'); i >= 0 {
+        line = line[:i]
+    }
+    return strings.TrimSpace(line)
+}
+
+// trimLine strips surrounding whitespace and drops whole-line "#"
+// comments, leaving a '#' later in the line alone: for lists whose
+// values (passwords, JSON strings) may contain one
+func trimLine(line string) string {
+    line = strings.TrimSpace(line)
+    if strings.HasPrefix(line, "#") {
+        return ""
+    }
+    return line
+}
+
+// --- Logging helper ---
+// Logs go to stderr so stdout can carry only proxy lines (see -out -).
+// Workers log concurrently, so every write goes through logOut, which
+// serializes whole messages and keeps lines from interleaving.
+var logOut = &syncWriter{w: os.Stderr}
+
+type syncWriter struct {
+    mu sync.Mutex
+    w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.w.Write(p)
+}
+
+// checkWritable creates dir if needed and proves a file can be created
+// in it
+func checkWritable(dir string) error {
+    if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+        return err
+    }
+    f, err := os.CreateTemp(dir, ".proxyscanner-*")
+    if err != nil {
+        return err
+    }
+    f.Close()
+    return os.Remove(f.Name())
+}
+
+func logPrint(level string, currentLevel string, format string, args ...interface{}) {
+    levels := map[string]int{"quiet": 0, "info": 1, "debug": 2}
+    if levels[currentLevel] >= levels[level] {
+        logOut.Write([]byte(fmt.Sprintf(format, args...)))
+    }
+}
+
+// --- Port Range Parser ---
+func parsePortRange(s string) (int, int, error) {
+    parts := strings.Split(s, "-")
+    if len(parts) != 2 {
+        return 0, 0, fmt.Errorf("range must be start-end")
+    }
+    start, err := strconv.Atoi(parts[0])
+    if err != nil {
+        return 0, 0, err
+    }
+    end, err := strconv.Atoi(parts[1])
+    if err != nil {
+        return 0, 0, err
+    }
+    return start, end, nil
+}
+
+// --- Target Expander ---
+
+// expandTarget expands a CIDR, a start-end IP range, or a single IP
+func expandTarget(s string) ([]string, error) {
+    s = strings.TrimSpace(s)
+    if strings.Contains(s, "/") {
+        _, ipnet, err := net.ParseCIDR(s)
+        if err != nil {
+            return nil, err
+        }
+        return expandCIDR(ipnet), nil
+    }
+    if strings.Contains(s, "-") {
+        return expandRange(s)
+    }
+    ip := net.ParseIP(s)
+    if ip == nil {
+        return nil, fmt.Errorf("invalid IP address")
+    }
+    return []string{ip.String()}, nil
+}
+
+// targetSize returns how many addresses expandTarget would produce for s,
+// computed from the prefix length or range bounds without expanding, so
+// a huge block can be refused before it is held in memory. Lines that
+// would not expand count zero.
+func targetSize(s string) *big.Int {
+    n, err := sizeTarget(s)
+    if err != nil {
+        return new(big.Int)
+    }
+    return n
+}
+
+// sizeTarget parses s like expandTarget and returns how many addresses
+// it stands for, without expanding it
+func sizeTarget(s string) (*big.Int, error) {
+    s = strings.TrimSpace(s)
+    if strings.Contains(s, "/") {
+        _, ipnet, err := net.ParseCIDR(s)
+        if err != nil {
+            return nil, err
+        }
+        ones, bits := ipnet.Mask.Size()
+        return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)), nil
+    }
+    if strings.Contains(s, "-") {
+        start, end, err := parseRange(s)
+        if err != nil {
+            return nil, err
+        }
+        n := new(big.Int).Sub(new(big.Int).SetBytes(end), new(big.Int).SetBytes(start))
+        return n.Add(n, big.NewInt(1)), nil
+    }
+    if net.ParseIP(s) == nil {
+        return nil, fmt.Errorf("invalid IP address")
+    }
+    return big.NewInt(1), nil
+}
+
+// expandRange expands an inclusive "start-end" IP range
+func expandRange(s string) ([]string, error) {
+    start, end, err := parseRange(s)
+    if err != nil {
+        return nil, err
+    }
+    var list []string
+    ip := append(net.IP(nil), start...)
+    for {
+        list = append(list, ip.String())
+        if ip.Equal(end) {
+            break
+        }
+        ip = nextIP(ip)
+    }
+    return list, nil
+}
+
+// parseRange parses an inclusive "start-end" IP range, returning both
+// ends as 4 bytes for IPv4 and 16 for IPv6
+func parseRange(s string) (net.IP, net.IP, error) {
+    parts := strings.Split(s, "-")
+    if len(parts) != 2 {
+        return nil, nil, fmt.Errorf("range must be start-end")
+    }
+    start := net.ParseIP(strings.TrimSpace(parts[0]))
+    end := net.ParseIP(strings.TrimSpace(parts[1]))
+    if start == nil || end == nil {
+        return nil, nil, fmt.Errorf("invalid IP in range")
+    }
+    if (start.To4() == nil) != (end.To4() == nil) {
+        return nil, nil, fmt.Errorf("range endpoints must be the same address family")
+    }
+    if start.To4() != nil {
+        start, end = start.To4(), end.To4()
+    }
+    if bytes.Compare(start, end) > 0 {
+        return nil, nil, fmt.Errorf("range start is after end")
+    }
+    return start, end, nil
+}
+
+// --- CIDR Expander ---
+func expandCIDR(ipnet *net.IPNet) []string {
+    var list []string
+    for ip := ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); {
+        list = append(list, ip.String())
+        // Counting up from the network address only reaches all-zeros
+        // when the carry overflowed the whole address, i.e. the block
+        // ends at 255.255.255.255 (or ffff:...:ffff). Stop there: for
+        // 0.0.0.0/0 Contains would accept the wrapped address and loop.
+        if ip = nextIP(ip); ip == nil || ip.IsUnspecified() {
+            break
+        }
+    }
+    return list
+}
+
+// nextIP increments ip in place. IPv4 (including IPv4-mapped) addresses
+// are stepped in their 4-byte form, anything else as 16 bytes; malformed
+// input yields nil rather than panicking.
+func nextIP(ip net.IP) net.IP {
+    if v4 := ip.To4(); v4 != nil {
+        ip = v4
+    } else if ip = ip.To16(); ip == nil {
+        return nil
+    }
+    for i := len(ip) - 1; i >= 0; i-- {
+        ip[i]++
+        if ip[i] != 0 {
+            break
+        }
+    }
+    return ip
+}
+
+// --- Dialing ---
+
+// newUpstreamDialer builds a dialer that tunnels every connection through
+// the given socks5:// upstream proxy, reached with the direct dialer
+func newUpstreamDialer(rawURL string, timeoutSec int, direct netDialer) (Dialer, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+        return nil, fmt.Errorf("unsupported upstream scheme %q (want socks5://)", u.Scheme)
+    }
+    d, err := proxy.FromURL(u, direct.dialer(time.Duration(timeoutSec)*time.Second))
+    if err != nil {
+        return nil, err
+    }
+    return upstreamDialer{d: d}, nil
+}
+
+// dialTimeout connects to address directly or via the -through upstream
+// When the process runs out of file descriptors the dial is retried after
+// a pause instead of counting the target as dead.
+func (s *Scanner) dialTimeout(address string, timeout time.Duration) (net.Conn, error) {
+    return s.connect(address, timeout, false)
+}
+
+// dialHandshake is dialTimeout for callers that take the connect itself
+// as the answer: the TCP handshake completes before it returns, even with
+// -tfo
+func (s *Scanner) dialHandshake(address string, timeout time.Duration) (net.Conn, error) {
+    return s.connect(address, timeout, true)
+}
+
+// connect implements dialTimeout and dialHandshake. Only dials that did
+// the handshake are timed for -dial-timeout-adaptive.
+func (s *Scanner) connect(address string, timeout time.Duration, handshake bool) (net.Conn, error) {
+    if s.Backoff != nil {
+        s.Backoff.wait()
+    }
+    if s.Adaptive != nil {
+        timeout = s.Adaptive.timeout(timeout)
+    }
+    var conn net.Conn
+    var err error
+    for attempt := 0; attempt < fdMaxRetries; attempt++ {
+        s.FDs.wait()
+        start := time.Now()
+        conn, err = s.rawDial(address, timeout, handshake)
+        if err == nil && s.Adaptive != nil && (handshake || !s.TFO) {
+            s.Adaptive.observe(time.Since(start))
+        }
+        if !isFDExhausted(err) {
+            break
+        }
+        s.FDs.exhausted(err)
+    }
+    if s.Backoff != nil {
+        s.Backoff.observe(err)
+    }
+    if err == nil && s.Trace != nil {
+        conn = &traceConn{Conn: conn, w: s.Trace}
+    }
+    return conn, err
+}
+
+func (s *Scanner) rawDial(address string, timeout time.Duration, handshake bool) (net.Conn, error) {
+    if handshake && s.TFO {
+        ctx, cancel := context.WithTimeout(withHandshake(context.Background()), timeout)
+        defer cancel()
+        return s.Dialer.DialContext(ctx, "tcp", address)
+    }
+    return s.Dialer.DialTimeout("tcp", address, timeout)
 }
 
-func main() {
-    // --- CLI Flags ---
-    timeout := flag.Int("timeout", 3, "connection timeout (seconds)")
-    workers := flag.Int("workers", runtime.NumCPU()*2, "number of concurrent workers")
-    refreshInterval := flag.Int("refresh-interval", 60, "interval to re-test proxies (minutes)")
-    outputDir := flag.String("output-dir", ".", "directory for output file(s)")
-    logLevel := flag.String("log-level", "info", "log level (info|debug|quiet)")
-    configFile := flag.String("config", "", "JSON config file (optional)")
-    flag.Parse()
+// dial connects to address using the scanner's check timeout
+func (s *Scanner) dial(address string) (net.Conn, error) {
+    return s.dialTimeout(address, time.Duration(s.Timeout)*time.Second)
+}
 
-    // --- Load Config from File if Provided ---
-    if *configFile != "" {
-        file, err := os.Open(*configFile)
+// --- Proxy Checks ---
+
+// isOpen reports whether a plain TCP connect succeeds within timeout
+func (s *Scanner) isOpen(address string, timeout time.Duration) bool {
+    conn, err := s.dialHandshake(address, timeout)
+    if err != nil {
+        return false
+    }
+    conn.Close()
+    return true
+}
+
+// grabBanner reads up to maxBytes of whatever the service sends on connect
+func (s *Scanner) grabBanner(address string, maxBytes int) (string, bool) {
+    conn, err := s.dial(address)
+    if err != nil {
+        return "", false
+    }
+    defer conn.Close()
+    conn.SetReadDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
+    buf := make([]byte, maxBytes)
+    n, _ := io.ReadAtLeast(conn, buf, 1)
+    if n <= 0 {
+        return "", false
+    }
+    return string(buf[:n]), true
+}
+
+// Proxy describes a detected proxy (or, in portscan / -grab-banner runs,
+// an open port with Protocol "OPEN" / "BANNER", and with -report-open-tcp
+// an unidentified listener as "OPEN-TCP")
+type Proxy struct {
+    Address      string
+    Protocol     string
+    Latency      time.Duration // duration of the successful check
+    HTTPVersion  string        // version the proxy answered with, HTTP only
+    HTTPMethod   string        // method the proxy accepted, HTTP only
+    Banner       string        // service banner, BANNER results only
+    AuthRequired string        // SOCKS5 server refused no-auth: "GSSAPI" or "auth"; SOCKS4 wants "ident"
+    RDNS         string        // PTR name, with -enrich rdns
+    Org          string        // network owner, with -enrich whois
+    BytesPerSec  float64       // download throughput, with -bandwidth-test
+    Tags         []string      // metadata from -targets-jsonl, carried to the output
+    Software     string        // best-effort guess such as "Squid 3.5.27", with -fingerprint
+    ChainDepth   int           // proxy hops the judge saw, HTTP only, with -judge
+    Capabilities []string      // optional features that work, e.g. "SOCKS5-BIND" with -check-bind
+    Run          string        // -tag, or the generated run ID
+    RealTarget   string        // -real-target URL the HTTP proxy also fetched
+    User         string        // username from -proxy-credentials-file the check authenticated with
+}
+
+// detect runs the protocol checks in order, then -custom-check if set, and
+// returns the first match, or false if address is not a working proxy.
+// The checks that failed before the match (or all of them) are returned
+// as attempts. A check that cannot connect ends the cascade: the others
+// would dial the same unreachable port and each wait out the timeout.
+func (s *Scanner) detect(address string) (Proxy, []Attempt, bool) {
+    p := Proxy{Address: address}
+    var attempts []Attempt
+    for _, protocol := range s.Reorder.order(s.Order) {
+        if s.Limits.full(protocol) {
+            continue
+        }
+        start := time.Now()
+        err := s.check(protocol, address, &p)
+        if err == nil {
+            p.Protocol, p.Latency = protocol, time.Since(start)
+            if p.AuthRequired == "" {
+                s.Reorder.hit(protocol)
+            }
+            if s.Fingerprint && (protocol == "SOCKS4" || protocol == "SOCKS5") {
+                p.Software = s.fingerprintSOCKS(address)
+            }
+            if s.CheckBind && protocol == "SOCKS5" && p.AuthRequired == "" && s.checkBind(address) {
+                p.Capabilities = append(p.Capabilities, "SOCKS5-BIND")
+            }
+            return p, attempts, true
+        }
+        attempts = append(attempts, Attempt{Protocol: protocol, Err: err})
+        var ce *connectError
+        if errors.As(err, &ce) {
+            return p, attempts, false
+        }
+    }
+    if s.CustomCheck != "" {
+        host, portStr, err := net.SplitHostPort(address)
         if err != nil {
-            fmt.Fprintf(os.Stderr, "Error opening config file: %v\n", err)
-            os.Exit(1)
+            return p, attempts, false
         }
-        defer file.Close()
-        dec := json.NewDecoder(file)
-        cfg := Config{}
-        if err := dec.Decode(&cfg); err != nil {
-            fmt.Fprintf(os.Stderr, "Invalid JSON config: %v\n", err)
-            os.Exit(1)
+        port, _ := strconv.Atoi(portStr)
+        start := time.Now()
+        if label, ok := s.runCustomCheck(host, port); ok {
+            p.Protocol, p.Latency = label, time.Since(start)
+            return p, attempts, true
         }
-        if *timeout == 3 && cfg.Timeout != 0 {
-            *timeout = cfg.Timeout
+        attempts = append(attempts, Attempt{Protocol: "CUSTOM", Err: errCustomRejected})
+    }
+    return p, attempts, false
+}
+
+// defaultOrder is the cascade used when -protocol-order is not given
+var defaultOrder = []string{"HTTP", "SOCKS4", "SOCKS5"}
+
+// check runs the named protocol check against address and returns why it
+// failed, or nil on success; checks may fill in protocol-specific details
+// on p
+func (s *Scanner) check(protocol string, address string, p *Proxy) error {
+    switch protocol {
+    case "HTTP":
+        return s.checkHTTP(address, p)
+    case "SOCKS4":
+        return s.checkSOCKS4(address, p)
+    case "SOCKS5":
+        return s.checkSOCKS5(address, p)
+    case "HTTPS-PROXY":
+        return s.checkHTTPSProxy(address, p)
+    case "SOCKS5-TLS":
+        return s.checkSOCKS5TLS(address, p)
+    }
+    return fmt.Errorf("unknown protocol %s", protocol)
+}
+
+// parseProtocolOrder turns "socks5,http" into a full cascade: the listed
+// protocols first, then any remaining ones in their default order
+func parseProtocolOrder(s string) ([]string, error) {
+    var order []string
+    seen := make(map[string]bool)
+    for _, part := range strings.Split(s, ",") {
+        protocol := strings.ToUpper(strings.TrimSpace(part))
+        if protocol == "" {
+            continue
         }
-        if *workers == runtime.NumCPU()*2 && cfg.Workers != 0 {
-            *workers = cfg.Workers
+        known := false
+        for _, p := range defaultOrder {
+            known = known || p == protocol
         }
-        if *refreshInterval == 60 && cfg.RefreshInterval != 0 {
-            *refreshInterval = cfg.RefreshInterval
+        if !known {
+            return nil, fmt.Errorf("unknown protocol %q", part)
         }
-        if *outputDir == "." && cfg.OutputDir != "" {
-            *outputDir = cfg.OutputDir
+        if !seen[protocol] {
+            seen[protocol] = true
+            order = append(order, protocol)
         }
-        if *logLevel == "info" && cfg.LogLevel != "" {
-            *logLevel = cfg.LogLevel
+    }
+    for _, p := range defaultOrder {
+        if !seen[p] {
+            order = append(order, p)
         }
     }
+    return order, nil
+}
 
-    // --- Read CIDRs from Cidr.txt ---
-    cidrList, err := readLines("Cidr.txt")
-    if err != nil {
-        log.Fatalf("Error reading Cidr.txt: %v", err)
+// HTTP: proxy a request for the next -test-urls target
+func (s *Scanner) checkHTTP(address string, p *Proxy) error {
+    return s.httpMethods(p, func(req string) (*httpResponse, error) {
+        conn, err := s.dial(address)
+        if err != nil {
+            return nil, &connectError{err}
+        }
+        defer conn.Close()
+        return s.roundTrip(conn, req)
+    })
+}
+
+// HTTPS-PROXY: the same request, sent over TLS to the proxy itself
+func (s *Scanner) checkHTTPSProxy(address string, p *Proxy) error {
+    return s.httpMethods(p, func(req string) (*httpResponse, error) {
+        raw, err := s.dial(address)
+        if err != nil {
+            return nil, &connectError{err}
+        }
+        conn, err := s.tlsClient(raw)
+        if err != nil {
+            return nil, err
+        }
+        defer conn.Close()
+        return s.roundTrip(conn, req)
+    })
+}
+
+// tlsClient runs the -tls-sni/-tls-verify handshake on raw, closing raw
+// if it fails
+func (s *Scanner) tlsClient(raw net.Conn) (net.Conn, error) {
+    conn := tls.Client(raw, s.TLS)
+    conn.SetDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
+    if err := conn.Handshake(); err != nil {
+        raw.Close()
+        return nil, fmt.Errorf("TLS handshake: %w", err)
     }
+    return conn, nil
+}
 
-    // --- Read Ports from Ports.txt ---
-    portRanges, err := readLines("Ports.txt")
+// httpExchange sends one proxy request on a fresh connection and returns
+// the response
+type httpExchange func(req string) (*httpResponse, error)
+
+// httpMethods probes with the -http-method and, with -head-fallback,
+// again with HEAD when a GET is refused with 405, sending the proxy's
+// -proxy-credentials-file entry as Proxy-Authorization. The method that
+// succeeded is recorded on p.
+func (s *Scanner) httpMethods(p *Proxy, exchange httpExchange) error {
+    cred, hasCred := s.Credentials.lookup(p.Address)
+    if hasCred {
+        plain := exchange
+        exchange = func(req string) (*httpResponse, error) { return plain(withProxyAuth(req, cred)) }
+    }
+    method := s.HTTPMethod
+    err := s.httpProbe(method, p, exchange)
+    var se *statusError
+    if err != nil && s.HeadFallback && method == "GET" && errors.As(err, &se) && se.Code == http.StatusMethodNotAllowed {
+        method = "HEAD"
+        err = s.httpProbe(method, p, exchange)
+    }
     if err != nil {
-        log.Fatalf("Error reading Ports.txt: %v", err)
+        return err
     }
-
-    // --- Expand all CIDRs to IPs ---
-    var allIPs []string
-    for _, cidr := range cidrList {
-        _, ipnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+    p.HTTPMethod = method
+    p.User = cred.User
+    if s.RealTarget != nil {
+        resp, err := s.fetch(method, s.RealTarget, exchange)
+        if err == nil && !s.AcceptStatus.contains(resp.Code) {
+            err = &statusError{resp.Code}
+        }
         if err != nil {
-            log.Printf("Skipping invalid CIDR %s: %v", cidr, err)
-            continue
+            return &realTargetError{s.RealTarget.URL, err}
         }
-        ips := expandCIDR(ipnet)
-        allIPs = append(allIPs, ips...)
+        p.RealTarget = s.RealTarget.URL
+    }
+    return nil
+}
+
+// realTargetError is an HTTP proxy that passed the judge but could not
+// fetch the -real-target, i.e. one that only allows some target hosts
+type realTargetError struct {
+    url string
+    err error
+}
+
+func (e *realTargetError) Error() string {
+    return fmt.Sprintf("judge ok, real target %s failed: %v", e.url, e.err)
+}
+
+func (e *realTargetError) Unwrap() error {
+    return e.err
+}
+
+// statusError is a proxy response whose status is not in -accept-status
+type statusError struct {
+    Code int
+}
+
+func (e *statusError) Error() string {
+    return fmt.Sprintf("status %d not accepted", e.Code)
+}
+
+// httpProbe requests the next -test-urls target and checks the status.
+// With -follow-redirect, one 3xx to an http:// Location is followed and
+// the status of that second response decides.
+func (s *Scanner) httpProbe(method string, p *Proxy, exchange httpExchange) error {
+    target := s.HTTPTargets.pick()
+    resp, err := s.fetch(method, target, exchange)
+    if err != nil {
+        return err
+    }
+    p.HTTPVersion = resp.Version
+    ok := s.AcceptStatus.contains(resp.Code)
+    s.HTTPTargets.report(target, ok)
+    if !ok {
+        return &statusError{resp.Code}
     }
-    if len(allIPs) == 0 {
-        log.Fatal("No valid IPs found from CIDRs")
+    if s.Fingerprint {
+        p.Software = fingerprintHTTP(resp)
     }
+    if s.Judge {
+        p.ChainDepth = chainDepth(resp)
+    }
+    return nil
+}
 
-    // --- Parse all port ranges ---
-    var portsToScan []int
-    for _, pr := range portRanges {
-        pr = strings.TrimSpace(pr)
-        if strings.Contains(pr, "-") {
-            startPort, endPort, err := parsePortRange(pr)
-            if err != nil {
-                log.Printf("Skipping invalid port range %s: %v", pr, err)
-                continue
-            }
-            for p := startPort; p <= endPort; p++ {
-                portsToScan = append(portsToScan, p)
-            }
-        } else {
-            p, err := strconv.Atoi(pr)
-            if err != nil {
-                log.Printf("Skipping invalid port %s: %v", pr, err)
-                continue
+// fetch requests target through exchange, following one redirect with
+// -follow-redirect
+func (s *Scanner) fetch(method string, target *testTarget, exchange httpExchange) (*httpResponse, error) {
+    resp, err := exchange(s.httpRequest(method, target))
+    if err != nil {
+        return nil, err
+    }
+    if s.FollowRedirect && resp.Code >= 300 && resp.Code < 400 {
+        if next, ok := redirectTarget(target, resp.header("Location")); ok {
+            if resp, err = exchange(s.httpRequest(method, next)); err != nil {
+                return nil, fmt.Errorf("redirect to %s: %w", next.URL, err)
             }
-            portsToScan = append(portsToScan, p)
         }
     }
-    if len(portsToScan) == 0 {
-        log.Fatal("No valid ports found in Ports.txt")
-    }
+    return resp, nil
+}
 
-    // --- Prepare output file ---
-    os.MkdirAll(*outputDir, os.ModePerm)
-    outPath := *outputDir + string(os.PathSeparator) + "proxies.txt"
-    outFile, err := os.Create(outPath)
+// roundTrip writes req to conn and reads the response
+func (s *Scanner) roundTrip(conn net.Conn, req string) (*httpResponse, error) {
+    conn.Write([]byte(req))
+    conn.SetReadDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
+    resp, err := readResponse(conn, s.MaxResponseBytes)
     if err != nil {
-        log.Fatalf("Cannot create output file: %v", err)
+        return nil, fmt.Errorf("read response: %w", err)
     }
-    defer outFile.Close()
+    return resp, nil
+}
 
-    foundChan := make(chan string, 100)
-    var writerWg sync.WaitGroup
-    writerWg.Add(1)
-    go func() {
-        defer writerWg.Done()
-        writer := bufio.NewWriter(outFile)
-        for entry := range foundChan {
-            writer.WriteString(entry + "\n")
-            writer.Flush()
+// redirectTarget resolves a Location against from. Only http:// targets
+// can be requested through a plain proxy; anything else is not followed.
+func redirectTarget(from *testTarget, location string) (*testTarget, bool) {
+    if location == "" {
+        return nil, false
+    }
+    base, err := url.Parse(from.URL)
+    if err != nil {
+        return nil, false
+    }
+    u, err := base.Parse(location)
+    if err != nil || u.Scheme != "http" || u.Hostname() == "" {
+        return nil, false
+    }
+    port := 80
+    if u.Port() != "" {
+        if port, err = strconv.Atoi(u.Port()); err != nil {
+            return nil, false
         }
-    }()
+    }
+    return &testTarget{Host: u.Hostname(), Port: port, URL: u.String()}, true
+}
 
-    // --- Scanning ---
-    type Task struct{ IP string; Port int }
-    tasks := make(chan Task, *workers*2)
-    var scanWg sync.WaitGroup
-
-    for i := 0; i < *workers; i++ {
-        scanWg.Add(1)
-        go func() {
-            defer scanWg.Done()
-            for task := range tasks {
-                address := fmt.Sprintf("%s:%d", task.IP, task.Port)
-
-                logPrint("debug", *logLevel, "[*] Testing %s\n", address)
-
-                if checkHTTP(address, *timeout) {
-                    logPrint("info", *logLevel, "[+] %s → HTTP\n", address)
-                    foundChan <- fmt.Sprintf("%s - HTTP", address)
-                    continue
-                }
-                if checkSOCKS4(address, *timeout) {
-                    logPrint("info", *logLevel, "[+] %s → SOCKS4\n", address)
-                    foundChan <- fmt.Sprintf("%s - SOCKS4", address)
-                    continue
-                }
-                if checkSOCKS5(address, *timeout) {
-                    logPrint("info", *logLevel, "[+] %s → SOCKS5\n", address)
-                    foundChan <- fmt.Sprintf("%s - SOCKS5", address)
-                    continue
-                }
-            }
-        }()
+// httpRequest builds the proxy request for target in the -http-version
+// dialect. HTTP/1.0 needs no Host header and closes by default.
+func (s *Scanner) httpRequest(method string, target *testTarget) string {
+    if s.HTTPVersion == "1.0" {
+        return fmt.Sprintf("%s %s HTTP/1.0\r\n\r\n", method, target.URL)
     }
+    return fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", method, target.URL, target.hostHeader())
+}
+
+// httpResponse is the part of a proxy's HTTP response the checks look at
+type httpResponse struct {
+    Version string // e.g. "HTTP/1.0"
+    Code    int
+    Raw     []byte // everything read, status line included
+}
 
-    // Send all tasks
-    for _, ip := range allIPs {
-        for _, port := range portsToScan {
-            tasks <- Task{IP: ip, Port: port}
+// header returns the first value of the named response header, or ""
+func (r *httpResponse) header(name string) string {
+    lines := strings.Split(string(r.Raw), "\n")
+    for _, line := range lines[1:] {
+        line = strings.TrimRight(line, "\r")
+        if line == "" {
+            break
+        }
+        if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+            return strings.TrimSpace(v)
         }
     }
-    close(tasks)
-    scanWg.Wait()
-    close(foundChan)
-    writerWg.Wait()
+    return ""
 }
 
-// readLines reads all lines from a text file into a string slice
-func readLines(filename string) ([]string, error) {
-    file, err := os.Open(filename)
+// readResponse reads an HTTP response until limit bytes, EOF or the read
+// deadline, whichever comes first. The status line may arrive in any
+// number of packets.
+func readResponse(r io.Reader, limit int64) (*httpResponse, error) {
+    br := bufio.NewReader(io.LimitReader(r, limit))
+    line, err := br.ReadString('\n')
     if err != nil {
         return nil, err
     }
-    defer file.Close()
+    version, code, err := parseStatusLine(line)
+    if err != nil {
+        return nil, err
+    }
+    rest, _ := io.ReadAll(br)
+    return &httpResponse{Version: version, Code: code, Raw: append([]byte(line), rest...)}, nil
+}
 
-    var lines []string
-    scanner := bufio.NewScanner(file)
-    for scanner.Scan() {
-        line := strings.TrimSpace(scanner.Text())
-        if line != "" {
-            lines = append(lines, line)
+// parseStatusLine splits "HTTP/1.x NNN Reason" into version and code
+func parseStatusLine(line string) (string, int, error) {
+    fields := strings.Fields(line)
+    if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+        return "", 0, fmt.Errorf("malformed status line %q", line)
+    }
+    code, err := strconv.Atoi(fields[1])
+    if err != nil || code < 100 || code > 599 {
+        return "", 0, fmt.Errorf("invalid status code %q", fields[1])
+    }
+    return fields[0], code, nil
+}
+
+// --- HTTP Status Sets ---
+
+// statusSet is a list of inclusive status code ranges
+type statusSet [][2]int
+
+// parseStatusSet parses a comma-separated list of codes ("200"), ranges
+// ("200-299") and classes ("2xx")
+func parseStatusSet(s string) (statusSet, error) {
+    var set statusSet
+    for _, part := range strings.Split(s, ",") {
+        part = strings.ToLower(strings.TrimSpace(part))
+        switch {
+        case part == "":
+            continue
+        case len(part) == 3 && strings.HasSuffix(part, "xx"):
+            class, err := strconv.Atoi(part[:1])
+            if err != nil {
+                return nil, fmt.Errorf("invalid status class %q", part)
+            }
+            set = append(set, [2]int{class * 100, class*100 + 99})
+        case strings.Contains(part, "-"):
+            start, end, err := parsePortRange(part)
+            if err != nil {
+                return nil, fmt.Errorf("invalid status range %q: %v", part, err)
+            }
+            set = append(set, [2]int{start, end})
+        default:
+            code, err := strconv.Atoi(part)
+            if err != nil {
+                return nil, fmt.Errorf("invalid status code %q", part)
+            }
+            set = append(set, [2]int{code, code})
         }
     }
-    return lines, scanner.Err()
+    if len(set) == 0 {
+        return nil, fmt.Errorf("no status codes given")
+    }
+    return set, nil
 }
 
-// --- Logging helper ---
-func logPrint(level string, currentLevel string, format string, args ...interface{}) {
-    levels := map[string]int{"quiet": 0, "info": 1, "debug": 2}
-    if levels[currentLevel] >= levels[level] {
-        fmt.Printf(format, args...)
+func (set statusSet) contains(code int) bool {
+    for _, r := range set {
+        if code >= r[0] && code <= r[1] {
+            return true
+        }
     }
+    return false
 }
 
-// --- Port Range Parser ---
-func parsePortRange(s string) (int, int, error) {
-    parts := strings.Split(s, "-")
-    if len(parts) != 2 {
-        return 0, 0, fmt.Errorf("range must be start-end")
+// socks4Replies explains the SOCKS4 reply codes other than 0x5A granted
+var socks4Replies = map[byte]string{
+    0x5B: "rejected or failed",
+    0x5C: "ident unreachable",
+    0x5D: "ident user mismatch",
+}
+
+// SOCKS4: connect to the next -test-ips target
+func (s *Scanner) checkSOCKS4(address string, p *Proxy) error {
+    conn, err := s.dial(address)
+    if err != nil {
+        return &connectError{err}
     }
-    start, err := strconv.Atoi(parts[0])
+    defer conn.Close()
+    target := s.SOCKS4Targets.pick()
+    req, cred, err := s.socks4Request(address, target)
     if err != nil {
-        return 0, 0, err
+        return fmt.Errorf("resolve test target: %w", err)
     }
-    end, err := strconv.Atoi(parts[1])
+    conn.Write(req)
+    conn.SetReadDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
+    reply := make([]byte, 8)
+    n, err := conn.Read(reply)
     if err != nil {
-        return 0, 0, err
+        return fmt.Errorf("read reply: %w", err)
     }
-    return start, end, nil
+    if n < 2 {
+        return fmt.Errorf("short reply (%d bytes)", n)
+    }
+    // 0x5C and 0x5D are about our (missing) identd, not the target, so
+    // they say nothing about whether the target is reachable
+    ident := reply[1] == 0x5C || reply[1] == 0x5D
+    if ident && s.SOCKS4Ident {
+        p.AuthRequired = "ident"
+        return nil
+    }
+    ok := reply[1] == 0x5A
+    if !ident {
+        s.SOCKS4Targets.report(target, ok)
+    }
+    if !ok {
+        if reason, known := socks4Replies[reply[1]]; known {
+            return fmt.Errorf("request rejected (0x%02x %s)", reply[1], reason)
+        }
+        return fmt.Errorf("request rejected (0x%02x)", reply[1])
+    }
+    p.User = cred.User
+    return nil
 }
 
-// --- CIDR Expander ---
-func expandCIDR(ipnet *net.IPNet) []string {
-    var list []string
-    for ip := ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); ip = nextIP(ip) {
-        list = append(list, ip.String())
+// socks4Request builds a CONNECT to target for the SOCKS4 proxy at
+// address, resolving target to IPv4 and sending the user of the proxy's
+// credential, if any, as the user ID (SOCKS4 has no password)
+func (s *Scanner) socks4Request(address string, target *testTarget) ([]byte, credential, error) {
+    destIP, err := s.DNS.lookupIPv4(target.Host)
+    if err != nil {
+        return nil, credential{}, err
     }
-    return list
+    port := target.Port
+    req := []byte{0x04, 0x01, byte(port >> 8), byte(port & 0xFF)}
+    req = append(req, destIP...)
+    cred, _ := s.Credentials.lookup(address)
+    req = append(req, cred.User...)
+    return append(req, 0x00), cred, nil
 }
 
-func nextIP(ip net.IP) net.IP {
-    ip = ip.To4()
-    for i := len(ip) - 1; i >= 0; i-- {
-        ip[i]++
-        if ip[i] != 0 {
-            break
-        }
-    }
-    return ip
+// SOCKS5: connect to the next -test-urls target via hostname
+func (s *Scanner) checkSOCKS5(address string, p *Proxy) error {
+    return s.socks5Connect(address, p, false)
 }
 
-// --- Proxy Checks ---
+// SOCKS5-TLS: the same handshake, over TLS to the proxy itself
+func (s *Scanner) checkSOCKS5TLS(address string, p *Proxy) error {
+    return s.socks5Connect(address, p, true)
+}
 
-// HTTP: request to www.google.com
-func checkHTTP(address string, timeoutSec int) bool {
-    conn, err := net.DialTimeout("tcp", address, time.Duration(timeoutSec)*time.Second)
+// socks5Connect greets address, over TLS if overTLS, and asks it to
+// CONNECT to the next -test-urls target
+func (s *Scanner) socks5Connect(address string, p *Proxy, overTLS bool) error {
+    conn, method, err := s.socks5Greet(address, overTLS)
     if err != nil {
-        return false
+        return err
     }
     defer conn.Close()
-    request := "GET http://www.google.com/ HTTP/1.1\r\nHost: www.google.com\r\nConnection: close\r\n\r\n"
-    conn.Write([]byte(request))
-    conn.SetReadDeadline(time.Now().Add(time.Duration(timeoutSec) * time.Second))
-    buf := make([]byte, 4096)
-    n, err := conn.Read(buf)
-    if err != nil || n <= 0 {
-        return false
+    // We only offered no-auth (and the proxy's own credentials, if any),
+    // so GSSAPI or "no acceptable methods" means a real SOCKS5 server
+    // that needs auth we can't do: record it as such rather than as dead
+    switch method {
+    case 0x02:
+        cred, _ := s.Credentials.lookup(address)
+        p.User = cred.User
+    case 0x01:
+        p.AuthRequired = "GSSAPI"
+        return nil
+    case 0xFF:
+        p.AuthRequired = "auth"
+        return nil
+    }
+    target := s.HTTPTargets.pick()
+    conn.Write(s.socks5Request(0x01, target))
+    conn.SetReadDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
+    resp := make([]byte, 10)
+    n, err := conn.Read(resp)
+    if err != nil {
+        return fmt.Errorf("read reply: %w", err)
+    }
+    if n < 2 {
+        return fmt.Errorf("short reply (%d bytes)", n)
+    }
+    ok := resp[1] == 0x00
+    s.HTTPTargets.report(target, ok)
+    if !ok {
+        return fmt.Errorf("connect rejected (0x%02x)", resp[1])
+    }
+    return nil
+}
+
+// socks5Request builds a request for cmd (0x01 CONNECT, 0x02 BIND) to
+// target, by hostname or, with -resolve-once, by its cached IPv4 address
+func (s *Scanner) socks5Request(cmd byte, target *testTarget) []byte {
+    dest := target.Host
+    port := target.Port
+    req := []byte{0x05, cmd, 0x00, 0x03, byte(len(dest))}
+    req = append(req, []byte(dest)...)
+    if s.ResolveOnce {
+        if ip, err := s.DNS.lookupIPv4(dest); err == nil {
+            req = append([]byte{0x05, cmd, 0x00, 0x01}, ip...)
+        }
     }
-    resp := string(buf[:n])
-    return strings.Contains(resp, "HTTP/1.1") || strings.Contains(resp, "HTTP/1.0")
+    return append(req, byte(port>>8), byte(port&0xFF))
 }
 
-// SOCKS4: connect to Google IP 142.250.74.68:80
-func checkSOCKS4(address string, timeoutSec int) bool {
-    conn, err := net.DialTimeout("tcp", address, time.Duration(timeoutSec)*time.Second)
+// checkBind asks a SOCKS5 server for a BIND on a fresh connection
+// (-check-bind) and reports whether the first reply grants it with a
+// usable bind address. Nothing connects to the bound port; the server
+// drops it when we hang up.
+func (s *Scanner) checkBind(address string) bool {
+    conn, method, err := s.socks5Greet(address, false)
     if err != nil {
         return false
     }
     defer conn.Close()
-    destIP := net.ParseIP("142.250.74.68").To4() // Google
-    if destIP == nil {
+    if method != 0x00 && method != 0x02 {
         return false
     }
-    port := 80
-    req := []byte{0x04, 0x01, byte(port >> 8), byte(port & 0xFF)}
-    req = append(req, destIP...)
-    req = append(req, 0x00)
-    conn.Write(req)
-    conn.SetReadDeadline(time.Now().Add(time.Duration(timeoutSec) * time.Second))
-    reply := make([]byte, 8)
-    n, err := conn.Read(reply)
-    if err != nil || n < 2 {
+    conn.Write(s.socks5Request(0x02, s.HTTPTargets.pick()))
+    conn.SetReadDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
+    head := make([]byte, 4)
+    if _, err := io.ReadFull(conn, head); err != nil || head[0] != 0x05 || head[1] != 0x00 {
         return false
     }
-    return reply[1] == 0x5A
+    _, port, err := readSOCKS5Addr(conn, head[3])
+    return err == nil && port != 0
+}
+
+// readSOCKS5Addr reads the address and port that follow the ATYP byte of
+// a SOCKS5 request or reply
+func readSOCKS5Addr(r io.Reader, atyp byte) (string, int, error) {
+    var host string
+    switch atyp {
+    case 0x01, 0x04:
+        ip := make(net.IP, 4)
+        if atyp == 0x04 {
+            ip = make(net.IP, 16)
+        }
+        if _, err := io.ReadFull(r, ip); err != nil {
+            return "", 0, err
+        }
+        host = ip.String()
+    case 0x03:
+        n := make([]byte, 1)
+        if _, err := io.ReadFull(r, n); err != nil {
+            return "", 0, err
+        }
+        name := make([]byte, n[0])
+        if _, err := io.ReadFull(r, name); err != nil {
+            return "", 0, err
+        }
+        host = string(name)
+    default:
+        return "", 0, fmt.Errorf("address type 0x%02x", atyp)
+    }
+    port := make([]byte, 2)
+    if _, err := io.ReadFull(r, port); err != nil {
+        return "", 0, err
+    }
+    return host, int(port[0])<<8 | int(port[1]), nil
+}
+
+// greetingError is a SOCKS5 method-selection reply that was truncated or
+// named an undefined method, which borderline servers send now and then
+type greetingError struct {
+    err error
+}
+
+func (e *greetingError) Error() string { return e.err.Error() }
+func (e *greetingError) Unwrap() error { return e.err }
+
+// socks5Greet connects and offers no-auth, and username/password when
+// -proxy-credentials-file has an entry for address, returning the
+// connection and the selected method (0x00, 0x02 after the credentials
+// were accepted, 0x01 GSSAPI or 0xFF none acceptable). A
+// greetingError is retried on a fresh connection up to
+// -socks5-greet-retries times.
+func (s *Scanner) socks5Greet(address string, overTLS bool) (net.Conn, byte, error) {
+    for attempt := 0; ; attempt++ {
+        conn, method, err := s.socks5GreetOnce(address, overTLS)
+        var ge *greetingError
+        if err == nil || !errors.As(err, &ge) || attempt >= s.GreetRetries {
+            return conn, method, err
+        }
+    }
 }
 
-// SOCKS5: connect to www.google.com:80 via hostname
-func checkSOCKS5(address string, timeoutSec int) bool {
-    conn, err := net.DialTimeout("tcp", address, time.Duration(timeoutSec)*time.Second)
+func (s *Scanner) socks5GreetOnce(address string, overTLS bool) (net.Conn, byte, error) {
+    conn, err := s.dial(address)
     if err != nil {
-        return false
+        return nil, 0, &connectError{err}
     }
-    defer conn.Close()
-    conn.Write([]byte{0x05, 0x01, 0x00})
-    conn.SetReadDeadline(time.Now().Add(time.Duration(timeoutSec) * time.Second))
+    if overTLS {
+        if conn, err = s.tlsClient(conn); err != nil {
+            return nil, 0, err
+        }
+    }
+    cred, hasCred := s.Credentials.lookup(address)
+    if hasCred {
+        conn.Write([]byte{0x05, 0x02, 0x00, 0x02})
+    } else {
+        conn.Write([]byte{0x05, 0x01, 0x00})
+    }
+    conn.SetReadDeadline(time.Now().Add(time.Duration(s.Timeout) * time.Second))
     resp := make([]byte, 2)
-    if _, err := conn.Read(resp); err != nil || resp[1] != 0x00 {
-        return false
+    if n, err := io.ReadFull(conn, resp); err != nil {
+        conn.Close()
+        if n > 0 {
+            return nil, 0, &greetingError{fmt.Errorf("truncated greeting (%d bytes): %w", n, err)}
+        }
+        return nil, 0, fmt.Errorf("read greeting: %w", err)
     }
-    dest := "www.google.com"
-    port := 80
-    req := []byte{0x05, 0x01, 0x00, 0x03, byte(len(dest))}
-    req = append(req, []byte(dest)...)
-    req = append(req, byte(port>>8), byte(port&0xFF))
-    conn.Write(req)
-    conn.SetReadDeadline(time.Now().Add(time.Duration(timeoutSec) * time.Second))
-    resp = make([]byte, 10)
-    n, err := conn.Read(resp)
-    if err != nil || n < 2 {
-        return false
+    if resp[0] != 0x05 {
+        conn.Close()
+        return nil, 0, fmt.Errorf("greeting version 0x%02x, not SOCKS5", resp[0])
+    }
+    switch resp[1] {
+    case 0x00, 0x01, 0xFF:
+        return conn, resp[1], nil
+    case 0x02:
+        if !hasCred {
+            break
+        }
+        if err := socks5Auth(conn, cred); err != nil {
+            conn.Close()
+            return nil, 0, err
+        }
+        return conn, resp[1], nil
     }
-    return resp[1] == 0x00
+    conn.Close()
+    return nil, 0, &greetingError{fmt.Errorf("unexpected method 0x%02x", resp[1])}
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "math/big"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// jsonlTarget is one -targets-jsonl line: a single ip/port, or a cidr
+// (any form Cidr.txt accepts) with a list of ports
+type jsonlTarget struct {
+    IP    string   `json:"ip"`
+    Port  int      `json:"port"`
+    CIDR  string   `json:"cidr"`
+    Ports []int    `json:"ports"`
+    Tags  []string `json:"tags"`
+    User  string   `json:"user"`
+    Pass  string   `json:"pass"`
+}
+
+// readTargetsJSONL builds tasks from a -targets-jsonl file, each carrying
+// its line's tags, and collects the "user"/"pass" of the lines that
+// have one for every address they expand to. Like the Cidr.txt check,
+// a file whose "cidr" lines add up to more than maxTasks tasks (0
+// disables) is refused from the prefix sizes, before anything is
+// expanded.
+func readTargetsJSONL(filename string, maxTasks int) ([]Task, credentials) {
+    lines := readDataFile(filename, `one {"ip":"1.2.3.4","port":8080,"tags":["us"]} object per line`)
+    var tasks []Task
+    creds := make(credentials)
+    var bad invalidLines
+    total := new(big.Int)
+    for _, line := range lines {
+        var t jsonlTarget
+        if err := json.Unmarshal([]byte(line), &t); err != nil {
+            skipWarn("Skipping invalid target line %s: %v", line, err)
+            bad.add(line)
+            continue
+        }
+        ports := t.Ports
+        if t.Port != 0 {
+            ports = append(ports, t.Port)
+        }
+        if t.CIDR == "" && net.ParseIP(t.IP) == nil {
+            skipWarn("Skipping invalid target line %s: bad or missing ip", line)
+            bad.add(line)
+            continue
+        }
+        if len(ports) == 0 {
+            skipWarn("Skipping invalid target line %s: no port", line)
+            bad.add(line)
+            continue
+        }
+        valid := true
+        for _, port := range ports {
+            valid = valid && port >= 1 && port <= 65535
+        }
+        if !valid {
+            skipWarn("Skipping invalid target line %s: port out of range", line)
+            bad.add(line)
+            continue
+        }
+        if len(t.User) > 255 || len(t.Pass) > 255 {
+            skipWarn("Skipping invalid target line %s: user and password must be at most 255 bytes", line)
+            bad.add(line)
+            continue
+        }
+        ips := []string{t.IP}
+        if t.CIDR != "" {
+            size := new(big.Int).Mul(targetSize(t.CIDR), big.NewInt(int64(len(ports))))
+            if total.Add(total, size); maxTasks > 0 && total.Cmp(big.NewInt(int64(maxTasks))) > 0 {
+                log.Fatalf("%s: %s tasks exceed -max-tasks %d by line %s; narrow the targets or ports, or pass -force", filename, total, maxTasks, line)
+            }
+            expanded, err := expandTarget(t.CIDR)
+            if err != nil {
+                skipWarn("Skipping invalid target line %s: %v", line, err)
+                bad.add(line)
+                continue
+            }
+            ips = expanded
+        } else {
+            total.Add(total, big.NewInt(int64(len(ports))))
+        }
+        for _, ip := range ips {
+            for _, port := range ports {
+                task := Task{IP: ip, Port: port, Tags: t.Tags}
+                if t.User != "" {
+                    creds[task.Address()] = credential{t.User, t.Pass}
+                }
+                tasks = append(tasks, task)
+            }
+        }
+    }
+    if len(tasks) == 0 {
+        inputFatal(exitInputInvalid, "No valid targets in %s: all %d lines are invalid (%s)", filename, len(bad), bad.sample())
+    }
+    return tasks, creds
+}
+
+// readReplayFile reads the addresses of an earlier results file for
+// -replay: proxies.txt lines ("IP:PORT - SOCKS5", "IP:PORT open", ...),
+// JSON lines, or a JSON array such as the /proxies API output. Entries
+// with an "address" field are accepted; duplicates are dropped.
+func readReplayFile(filename string) []Task {
+    data, err := os.ReadFile(filename)
+    if os.IsNotExist(err) {
+        inputFatal(exitInputMissing, "Replay file %s not found", filename)
+    }
+    if err == nil {
+        data, err = gunzipBytes(data)
+    }
+    if err != nil {
+        inputFatal(exitInputMissing, "Cannot read %s: %v", filename, err)
+    }
+
+    var addresses []string
+    var entries []struct {
+        Address string `json:"address"`
+    }
+    if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+        if err := json.Unmarshal(data, &entries); err != nil {
+            inputFatal(exitInputInvalid, "Cannot parse %s as JSON: %v", filename, err)
+        }
+        for _, e := range entries {
+            addresses = append(addresses, e.Address)
+        }
+    } else {
+        lines, err := parseLines(strings.NewReader(string(data)))
+        if err != nil {
+            inputFatal(exitInputMissing, "Cannot read %s: %v", filename, err)
+        }
+        for _, line := range lines {
+            if strings.HasPrefix(line, "{") {
+                var e struct {
+                    Address string `json:"address"`
+                }
+                json.Unmarshal([]byte(line), &e)
+                addresses = append(addresses, e.Address)
+                continue
+            }
+            addresses = append(addresses, strings.Fields(line)[0])
+        }
+    }
+    if len(addresses) == 0 {
+        inputFatal(exitInputEmpty, "Replay file %s has no entries", filename)
+    }
+
+    var tasks []Task
+    var bad invalidLines
+    seen := make(map[string]bool)
+    for _, address := range addresses {
+        host, portStr, err := net.SplitHostPort(address)
+        port, perr := strconv.Atoi(portStr)
+        if err != nil || perr != nil || net.ParseIP(host) == nil {
+            skipWarn("Skipping invalid replay entry %q", address)
+            bad.add(address)
+            continue
+        }
+        t := Task{IP: host, Port: port}
+        if !seen[t.Address()] {
+            seen[t.Address()] = true
+            tasks = append(tasks, t)
+        }
+    }
+    if len(tasks) == 0 {
+        inputFatal(exitInputInvalid, "No valid addresses in %s: all %d entries are invalid (%s)", filename, len(bad), bad.sample())
+    }
+    return tasks
+}
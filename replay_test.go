@@ -0,0 +1,69 @@
+package main
+
+import (
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReadTargetsJSONLKeepsHash(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "targets.jsonl")
+    data := "# exported targets\n" +
+        `{"ip":"192.0.2.1","port":1080,"tags":["#us","dc#2"]}` + "\n" +
+        `{"ip":"192.0.2.2","port":8080,"user":"alice","pass":"p#ss"}` + "\n"
+    if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+        t.Fatal(err)
+    }
+    tasks, creds := readTargetsJSONL(file, 0)
+    want := []Task{
+        {IP: "192.0.2.1", Port: 1080, Tags: []string{"#us", "dc#2"}},
+        {IP: "192.0.2.2", Port: 8080},
+    }
+    if !reflect.DeepEqual(tasks, want) {
+        t.Errorf("tasks = %+v, want %+v", tasks, want)
+    }
+    if cred := creds["192.0.2.2:8080"]; cred != (credential{"alice", "p#ss"}) {
+        t.Errorf("credential = %+v, want alice/p#ss", cred)
+    }
+}
+
+// TestReadTargetsJSONLMaxTasks runs readTargetsJSONL in a child process,
+// since refusing the file exits
+func TestReadTargetsJSONLMaxTasks(t *testing.T) {
+    if file := os.Getenv("JSONL_MAX_TASKS_FILE"); file != "" {
+        readTargetsJSONL(file, 1000)
+        os.Exit(0)
+    }
+    tests := []struct {
+        name, data string
+        refused    bool
+    }{
+        {"ipv6 /64", `{"cidr":"::/64","port":80}`, true},
+        {"ipv4 /0", `{"cidr":"0.0.0.0/0","ports":[80,443]}`, true},
+        {"sum over lines", `{"cidr":"192.0.2.0/24","ports":[80,443]}` + "\n" + `{"cidr":"198.51.100.0/24","ports":[80,443]}`, true},
+        {"within limit", `{"cidr":"192.0.2.0/24","ports":[80,443]}` + "\n" + `{"ip":"192.0.2.1","port":1080}`, false},
+    }
+    for _, tc := range tests {
+        file := filepath.Join(t.TempDir(), "targets.jsonl")
+        if err := os.WriteFile(file, []byte(tc.data+"\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+        cmd := exec.Command(os.Args[0], "-test.run=^TestReadTargetsJSONLMaxTasks$")
+        cmd.Env = append(os.Environ(), "JSONL_MAX_TASKS_FILE="+file)
+        start := time.Now()
+        out, err := cmd.CombinedOutput()
+        if tc.refused != (err != nil) {
+            t.Errorf("%s: err = %v, want refused %v\n%s", tc.name, err, tc.refused, out)
+        }
+        if tc.refused && !strings.Contains(string(out), "exceed -max-tasks 1000") {
+            t.Errorf("%s: output lacks the -max-tasks message:\n%s", tc.name, out)
+        }
+        if elapsed := time.Since(start); elapsed > 5*time.Second {
+            t.Errorf("%s: took %v; the file was expanded before the check", tc.name, elapsed)
+        }
+    }
+}
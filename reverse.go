@@ -0,0 +1,119 @@
+package main
+
+import (
+    "encoding/csv"
+    "io"
+    "log"
+    "sort"
+    "sync"
+)
+
+// reachRow is one proxy's line of the -reverse matrix
+type reachRow struct {
+    address  string
+    protocol string // first protocol that reached any target, "" if none
+    reached  []bool // per target, in -test-urls order
+}
+
+// pinned returns a copy of s whose checks all request target t
+func (s *Scanner) pinned(t *testTarget) *Scanner {
+    c := *s
+    c.HTTPTargets = &targetPool{targets: []*testTarget{t}}
+    c.SOCKS4Targets = &targetPool{targets: []*testTarget{{Host: t.Host, Port: t.Port, URL: t.URL}}}
+    return &c
+}
+
+// runReverse checks every listed proxy against every target in turn and
+// writes a CSV matrix of which proxy reached which target. It returns the
+// exit status: 0 if any proxy reached any target, 1 otherwise.
+func runReverse(s *Scanner, tasks []Task, targets []*testTarget, workers int, w io.Writer, logLevel string) int {
+    scanners := make([]*Scanner, len(targets))
+    for i, t := range targets {
+        scanners[i] = s.pinned(t)
+    }
+
+    jobs := make(chan string)
+    rows := make(chan reachRow)
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for address := range jobs {
+                row := reachRow{address: address, reached: make([]bool, len(targets))}
+                for i, ts := range scanners {
+                    p, _, ok := ts.detect(address)
+                    row.reached[i] = ok && p.AuthRequired == ""
+                    if row.reached[i] && row.protocol == "" {
+                        row.protocol = p.Protocol
+                    }
+                }
+                logPrint("debug", logLevel, "[*] %s reached %d of %d targets\n", address, count(row.reached), len(targets))
+                rows <- row
+            }
+        }()
+    }
+    go func() {
+        for _, t := range tasks {
+            jobs <- t.Address()
+        }
+        close(jobs)
+        wg.Wait()
+        close(rows)
+    }()
+
+    var matrix []reachRow
+    for row := range rows {
+        matrix = append(matrix, row)
+    }
+    sort.Slice(matrix, func(i, j int) bool { return matrix[i].address < matrix[j].address })
+
+    cw := csv.NewWriter(w)
+    header := []string{"address", "protocol"}
+    for _, t := range targets {
+        header = append(header, t.URL)
+    }
+    cw.Write(header)
+    reachedAny := false
+    for _, row := range matrix {
+        record := []string{row.address, row.protocol}
+        for _, ok := range row.reached {
+            cell := "fail"
+            if ok {
+                cell = "ok"
+            }
+            record = append(record, cell)
+        }
+        cw.Write(record)
+        reachedAny = reachedAny || row.protocol != ""
+    }
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+        log.Printf("Cannot write -reverse matrix: %v", err)
+        return 1
+    }
+    for i, t := range targets {
+        n := 0
+        for _, row := range matrix {
+            if row.reached[i] {
+                n++
+            }
+        }
+        logPrint("info", logLevel, "[*] %s reachable through %d of %d proxies\n", t.URL, n, len(matrix))
+    }
+    if !reachedAny {
+        return 1
+    }
+    return 0
+}
+
+// count returns how many of bs are true
+func count(bs []bool) int {
+    n := 0
+    for _, b := range bs {
+        if b {
+            n++
+        }
+    }
+    return n
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// rotatingFile is the output file with -max-output-size: once a write
+// takes it past maxSize it is closed and shifted to proxies.1.txt (the
+// previous .1 to .2, and so on, dropping the oldest beyond keep), and a
+// fresh file is opened. Rotation happens only after a newline, so a
+// record split across buffered writes stays in one file.
+type rotatingFile struct {
+    path    string
+    maxSize int64
+    keep    int
+    f       *os.File
+    size    int64
+}
+
+func newRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+    f, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+    return &rotatingFile{path: path, maxSize: maxSize, keep: keep, f: f}, nil
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+    end := bytes.LastIndexByte(b, '\n') + 1
+    if r.maxSize <= 0 || end == 0 {
+        n, err := r.f.Write(b)
+        r.size += int64(n)
+        return n, err
+    }
+    n, err := r.f.Write(b[:end])
+    r.size += int64(n)
+    if err != nil {
+        return n, err
+    }
+    if r.size >= r.maxSize {
+        if err := r.rotate(); err != nil {
+            return n, err
+        }
+    }
+    m, err := r.f.Write(b[end:])
+    r.size += int64(m)
+    return n + m, err
+}
+
+func (r *rotatingFile) Close() error {
+    return r.f.Close()
+}
+
+// rotatedName returns proxies.N.txt for proxies.txt
+func (r *rotatingFile) rotatedName(n int) string {
+    ext := filepath.Ext(r.path)
+    return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(r.path, ext), n, ext)
+}
+
+func (r *rotatingFile) rotate() error {
+    if err := r.f.Close(); err != nil {
+        return err
+    }
+    os.Remove(r.rotatedName(r.keep))
+    for n := r.keep - 1; n >= 1; n-- {
+        os.Rename(r.rotatedName(n), r.rotatedName(n+1))
+    }
+    if r.keep > 0 {
+        if err := os.Rename(r.path, r.rotatedName(1)); err != nil {
+            return err
+        }
+    }
+    f, err := os.Create(r.path)
+    if err != nil {
+        return err
+    }
+    r.f, r.size = f, 0
+    return nil
+}
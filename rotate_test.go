@@ -0,0 +1,61 @@
+package main
+
+import (
+    "bufio"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestRotatingFileKeepsLinesWhole(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "proxies.txt")
+    r, err := newRotatingFile(path, 100, 20)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // A small buffer makes most flushes end mid-line
+    w := bufio.NewWriterSize(r, 16)
+    line := "203.0.113.7:1080 - SOCKS5\n"
+    for i := 0; i < 50; i++ {
+        w.WriteString(line)
+    }
+    if err := w.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    r.Close()
+
+    names := []string{path}
+    for n := 1; n <= 20; n++ {
+        names = append(names, r.rotatedName(n))
+    }
+    total, files := 0, 0
+    for _, name := range names {
+        b, err := os.ReadFile(name)
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        files++
+        if len(b) > 0 && !strings.HasSuffix(string(b), "\n") {
+            t.Errorf("%s ends mid-line: %q", filepath.Base(name), b)
+        }
+        for _, l := range strings.SplitAfter(string(b), "\n") {
+            if l == "" {
+                continue
+            }
+            if l != line {
+                t.Errorf("%s has a split record %q", filepath.Base(name), l)
+            }
+            total++
+        }
+    }
+    if files < 2 {
+        t.Errorf("wrote %d files, want rotation", files)
+    }
+    if total != 50 {
+        t.Errorf("found %d records, want 50", total)
+    }
+}
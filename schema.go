@@ -0,0 +1,105 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "reflect"
+    "strings"
+    "time"
+)
+
+// schemaTypes are the JSON documents -dump-schema describes, by $defs
+// name. The schemas are derived from the structs' json tags, so they
+// follow the structs as fields are added.
+var schemaTypes = map[string]reflect.Type{
+    "config":  reflect.TypeOf(Config{}),
+    "event":   reflect.TypeOf(proxyEvent{}),
+    "proxy":   reflect.TypeOf(apiProxy{}),
+    "summary": reflect.TypeOf(Summary{}),
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// writeSchema prints a JSON Schema (draft 2020-12) with one $defs entry
+// per schemaTypes document
+func writeSchema(w io.Writer) error {
+    defs := make(map[string]interface{}, len(schemaTypes))
+    for name, t := range schemaTypes {
+        defs[name] = typeSchema(t)
+    }
+    // Every config file setting is optional, omitempty or not
+    delete(defs["config"].(map[string]interface{}), "required")
+    data, err := json.MarshalIndent(map[string]interface{}{
+        "$schema": "https://json-schema.org/draft/2020-12/schema",
+        "title":   "proxyscanner",
+        "$defs":   defs,
+    }, "", "  ")
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(append(data, '\n'))
+    return err
+}
+
+// typeSchema describes how encoding/json renders t
+func typeSchema(t reflect.Type) map[string]interface{} {
+    for t.Kind() == reflect.Pointer {
+        t = t.Elem()
+    }
+    if t == timeType {
+        return map[string]interface{}{"type": "string", "format": "date-time"}
+    }
+    switch t.Kind() {
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]interface{}{"type": "integer"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case reflect.Slice, reflect.Array:
+        return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+    case reflect.Struct:
+        return structSchema(t)
+    }
+    return map[string]interface{}{}
+}
+
+// structSchema lists t's exported fields under their json names,
+// flattening embedded structs as encoding/json does. Fields without
+// omitempty are always present, so they are required.
+func structSchema(t reflect.Type) map[string]interface{} {
+    props := make(map[string]interface{})
+    required := []string{}
+    var add func(t reflect.Type)
+    add = func(t reflect.Type) {
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+            if !f.IsExported() {
+                continue
+            }
+            tag := f.Tag.Get("json")
+            if tag == "-" {
+                continue
+            }
+            name, opts, _ := strings.Cut(tag, ",")
+            if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+                add(f.Type)
+                continue
+            }
+            if name == "" {
+                name = f.Name
+            }
+            props[name] = typeSchema(f.Type)
+            if !strings.Contains(opts, "omitempty") {
+                required = append(required, name)
+            }
+        }
+    }
+    add(t)
+    return map[string]interface{}{"type": "object", "properties": props, "required": required}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+    "sync"
+    "time"
+)
+
+// seenEntry is the last outcome for one IP:port in the -seen-db
+type seenEntry struct {
+    Address   string    `json:"address"`
+    Protocol  string    `json:"protocol,omitempty"` // "" when nothing was found
+    LatencyMs int64     `json:"latency_ms,omitempty"`
+    Checked   time.Time `json:"checked"`
+}
+
+// seenDB remembers outcomes across runs so addresses checked within ttl
+// are not dialed again. It is kept in memory and saved as JSON lines.
+type seenDB struct {
+    path    string
+    ttl     time.Duration
+    mu      sync.Mutex
+    entries map[string]seenEntry
+}
+
+// loadSeenDB reads path; a missing file is an empty database
+func loadSeenDB(path string, ttl time.Duration) (*seenDB, error) {
+    db := &seenDB{path: path, ttl: ttl, entries: make(map[string]seenEntry)}
+    f, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return db, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        var e seenEntry
+        if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Address != "" {
+            db.entries[e.Address] = e
+        }
+    }
+    return db, scanner.Err()
+}
+
+// fresh returns the entry for address if it was checked within the ttl
+func (db *seenDB) fresh(address string) (seenEntry, bool) {
+    if db == nil {
+        return seenEntry{}, false
+    }
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    e, ok := db.entries[address]
+    return e, ok && time.Since(e.Checked) < db.ttl
+}
+
+// record stores the outcome for address; p is nil when nothing was found
+func (db *seenDB) record(address string, p *Proxy) {
+    if db == nil {
+        return
+    }
+    e := seenEntry{Address: address, Checked: time.Now()}
+    if p != nil {
+        e.Protocol, e.LatencyMs = p.Protocol, p.Latency.Milliseconds()
+    }
+    db.mu.Lock()
+    db.entries[address] = e
+    db.mu.Unlock()
+}
+
+// save atomically rewrites the database file
+func (db *seenDB) save() error {
+    if db == nil {
+        return nil
+    }
+    tmp := db.path + ".tmp"
+    f, err := os.Create(tmp)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(f)
+    enc := json.NewEncoder(w)
+    db.mu.Lock()
+    for _, e := range db.entries {
+        enc.Encode(e)
+    }
+    db.mu.Unlock()
+    if err := w.Flush(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp, db.path)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "time"
+)
+
+// traceConn hex-dumps everything sent and received on a connection
+type traceConn struct {
+    net.Conn
+    w io.Writer
+}
+
+func (c *traceConn) Write(b []byte) (int, error) {
+    n, err := c.Conn.Write(b)
+    fmt.Fprintf(c.w, ">>> sent %d bytes\n%s", n, hex.Dump(b[:n]))
+    if err != nil {
+        fmt.Fprintf(c.w, ">>> write error: %v\n", err)
+    }
+    return n, err
+}
+
+func (c *traceConn) Read(b []byte) (int, error) {
+    n, err := c.Conn.Read(b)
+    if n > 0 {
+        fmt.Fprintf(c.w, "<<< received %d bytes\n%s", n, hex.Dump(b[:n]))
+    }
+    if err != nil {
+        fmt.Fprintf(c.w, "<<< read error: %v\n", err)
+    }
+    return n, err
+}
+
+// runSelfTest runs every protocol check against address with traffic
+// tracing on w, regardless of -protocol-order, and reports which passed.
+// It returns the process exit code: 0 if any check passed.
+func runSelfTest(s *Scanner, address string, w io.Writer) int {
+    s.Trace = w
+    passed := 0
+    protocols := append([]string(nil), defaultOrder...)
+    for _, protocol := range s.Order {
+        if protocol == "HTTPS-PROXY" || protocol == "SOCKS5-TLS" {
+            protocols = append(protocols, protocol)
+        }
+    }
+    for _, protocol := range protocols {
+        fmt.Fprintf(w, "=== %s check against %s ===\n", protocol, address)
+        p := Proxy{Address: address}
+        start := time.Now()
+        err := s.check(protocol, address, &p)
+        result := "PASS"
+        if err != nil {
+            result = "FAIL: " + err.Error()
+        } else {
+            passed++
+        }
+        fmt.Fprintf(w, "=== %s: %s (%dms)\n\n", protocol, result, time.Since(start).Milliseconds())
+    }
+    if s.CustomCheck != "" {
+        fmt.Fprintf(w, "=== custom check %s ===\n", s.CustomCheck)
+        host, portStr, _ := net.SplitHostPort(address)
+        port, _ := strconv.Atoi(portStr)
+        if label, ok := s.runCustomCheck(host, port); ok {
+            fmt.Fprintf(w, "=== custom: PASS (%s)\n\n", label)
+            passed++
+        } else {
+            fmt.Fprintf(w, "=== custom: FAIL\n\n")
+        }
+    }
+    if passed == 0 {
+        fmt.Fprintf(w, "No check passed for %s\n", address)
+        return 1
+    }
+    return 0
+}
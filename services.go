@@ -0,0 +1,60 @@
+package main
+
+import (
+    _ "embed"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// servicesTable maps service names to ports for -services
+//
+//go:embed services.txt
+var servicesTable string
+
+// parseServices reads a table in /etc/services format into name → ports.
+// Names and aliases are matched case-insensitively; only tcp entries count.
+func parseServices(table string) map[string][]int {
+    services := make(map[string][]int)
+    for _, line := range strings.Split(table, "\n") {
+        if i := strings.IndexByte(line, '#'); i >= 0 {
+            line = line[:i]
+        }
+        fields := strings.Fields(line)
+        if len(fields) < 2 {
+            continue
+        }
+        portStr, proto, ok := strings.Cut(fields[1], "/")
+        port, err := strconv.Atoi(portStr)
+        if !ok || proto != "tcp" || err != nil {
+            continue
+        }
+        names := append([]string{fields[0]}, fields[2:]...)
+        for _, name := range names {
+            services[strings.ToLower(name)] = append(services[strings.ToLower(name)], port)
+        }
+    }
+    return services
+}
+
+// servicePorts resolves comma-separated service names to their ports, in
+// order and without duplicates
+func servicePorts(names string) ([]int, error) {
+    services := parseServices(servicesTable)
+    var ports []int
+    for _, name := range strings.Split(names, ",") {
+        name = strings.ToLower(strings.TrimSpace(name))
+        if name == "" {
+            continue
+        }
+        p, ok := services[name]
+        if !ok {
+            return nil, fmt.Errorf("unknown service %q", name)
+        }
+        ports = mergePorts(ports, p)
+    }
+    if len(ports) == 0 {
+        return nil, fmt.Errorf("no service names given")
+    }
+    return ports, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// socks5ServerTimeout bounds one handshake on the in-process server
+const socks5ServerTimeout = 10 * time.Second
+
+// socks5Server is a minimal in-process SOCKS5 server (RFC 1928, with RFC
+// 1929 username/password auth) for the detect tests. It doubles as a
+// reference for what the check expects.
+type socks5Server struct {
+    User, Pass string         // require username/password auth when User is set
+    Reply      byte           // CONNECT reply code; 0x00 grants the request
+    Then       func(net.Conn) // after a granted CONNECT, answers as the destination
+}
+
+// listen serves on a random loopback port until the test ends
+func (srv *socks5Server) listen(t *testing.T) string {
+    t.Helper()
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("cannot start SOCKS5 server: %v", err)
+    }
+    t.Cleanup(func() { l.Close() })
+    go func() {
+        for {
+            conn, err := l.Accept()
+            if err != nil {
+                return
+            }
+            go srv.serve(conn)
+        }
+    }()
+    return l.Addr().String()
+}
+
+func (srv *socks5Server) serve(conn net.Conn) {
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(socks5ServerTimeout))
+    if err := srv.negotiate(conn); err != nil {
+        return
+    }
+    _, err := readSOCKS5Request(conn)
+    reply := srv.Reply
+    switch {
+    case errors.Is(err, errSOCKS5Command):
+        reply = 0x07 // command not supported
+    case err != nil:
+        return
+    }
+    conn.Write([]byte{0x05, reply, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+    if reply == 0x00 && srv.Then != nil {
+        srv.Then(conn)
+    }
+}
+
+// negotiate selects no-auth, or username/password when srv.User is set,
+// and runs the sub-negotiation
+func (srv *socks5Server) negotiate(conn net.Conn) error {
+    head := make([]byte, 2)
+    if _, err := io.ReadFull(conn, head); err != nil {
+        return err
+    }
+    if head[0] != 0x05 {
+        return fmt.Errorf("version 0x%02x", head[0])
+    }
+    methods := make([]byte, head[1])
+    if _, err := io.ReadFull(conn, methods); err != nil {
+        return err
+    }
+    want := byte(0x00)
+    if srv.User != "" {
+        want = 0x02
+    }
+    offered := false
+    for _, m := range methods {
+        offered = offered || m == want
+    }
+    if !offered {
+        conn.Write([]byte{0x05, 0xFF})
+        return fmt.Errorf("method 0x%02x not offered", want)
+    }
+    conn.Write([]byte{0x05, want})
+    if want != 0x02 {
+        return nil
+    }
+    user, pass, err := readSOCKS5Credentials(conn)
+    if err != nil {
+        return err
+    }
+    if user != srv.User || pass != srv.Pass {
+        conn.Write([]byte{0x01, 0x01})
+        return fmt.Errorf("bad credentials")
+    }
+    conn.Write([]byte{0x01, 0x00})
+    return nil
+}
+
+// readSOCKS5Credentials reads an RFC 1929 username/password request
+func readSOCKS5Credentials(r io.Reader) (user, pass string, err error) {
+    field := func() (string, error) {
+        n := make([]byte, 1)
+        if _, err := io.ReadFull(r, n); err != nil {
+            return "", err
+        }
+        b := make([]byte, n[0])
+        _, err := io.ReadFull(r, b)
+        return string(b), err
+    }
+    ver := make([]byte, 1)
+    if _, err = io.ReadFull(r, ver); err != nil {
+        return
+    }
+    if user, err = field(); err != nil {
+        return
+    }
+    pass, err = field()
+    return
+}
+
+// errSOCKS5Command is a well-formed request for a command other than CONNECT
+var errSOCKS5Command = errors.New("command not supported")
+
+// readSOCKS5Request reads a request and returns its destination as
+// host:port; IPv4, domain name and IPv6 addresses are accepted
+func readSOCKS5Request(r io.Reader) (string, error) {
+    head := make([]byte, 4)
+    if _, err := io.ReadFull(r, head); err != nil {
+        return "", err
+    }
+    host, port, err := readSOCKS5Addr(r, head[3])
+    if err != nil {
+        return "", err
+    }
+    dest := net.JoinHostPort(host, strconv.Itoa(port))
+    if head[1] != 0x01 {
+        return dest, errSOCKS5Command
+    }
+    return dest, nil
+}
+
+// testScanner returns a Scanner with the default cascade that dials
+// directly and never resolves a hostname
+func testScanner(t *testing.T) *Scanner {
+    t.Helper()
+    httpTargets, err := parseTestURLs("http://example.com/")
+    if err != nil {
+        t.Fatal(err)
+    }
+    socks4Targets, err := parseTestIPs("192.0.2.1:80")
+    if err != nil {
+        t.Fatal(err)
+    }
+    accept, err := parseStatusSet("2xx")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &Scanner{
+        Timeout:          2,
+        Dialer:           netDialer{},
+        Mode:             "proxy",
+        AcceptStatus:     accept,
+        MaxResponseBytes: 16384,
+        HTTPVersion:      "1.1",
+        HTTPMethod:       "GET",
+        Order:            defaultOrder,
+        HTTPTargets:      httpTargets,
+        SOCKS4Targets:    socks4Targets,
+        DNS:              newDNSCache(time.Minute, 0),
+        FDs:              &fdGuard{},
+    }
+}
+
+func TestDetectSOCKS5(t *testing.T) {
+    tests := []struct {
+        name   string
+        server socks5Server
+        cred   *credential // the scanner's -proxy-credentials-file entry
+        found  bool
+        auth   string // want Proxy.AuthRequired
+        user   string // want Proxy.User
+    }{
+        {"no auth", socks5Server{}, nil, true, "", ""},
+        {"auth required", socks5Server{User: "user", Pass: "pass"}, nil, true, "auth", ""},
+        {"credentials accepted", socks5Server{User: "user", Pass: "pass"}, &credential{"user", "pass"}, true, "", "user"},
+        {"credentials rejected", socks5Server{User: "user", Pass: "pass"}, &credential{"user", "wrong"}, false, "", ""},
+        {"connect rejected", socks5Server{Reply: 0x02}, nil, false, "", ""},
+    }
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            address := tc.server.listen(t)
+            s := testScanner(t)
+            if tc.cred != nil {
+                s.Credentials = credentials{address: *tc.cred}
+            }
+            p, attempts, ok := s.detect(address)
+            if ok != tc.found {
+                t.Fatalf("detect found = %v, want %v (attempts %v)", ok, tc.found, attempts)
+            }
+            if !ok {
+                last := attempts[len(attempts)-1]
+                if last.Protocol != "SOCKS5" || last.Err == nil {
+                    t.Errorf("last attempt = %s %v, want a SOCKS5 error", last.Protocol, last.Err)
+                }
+                return
+            }
+            if p.Protocol != "SOCKS5" {
+                t.Errorf("protocol = %q, want SOCKS5", p.Protocol)
+            }
+            if p.AuthRequired != tc.auth {
+                t.Errorf("auth required = %q, want %q", p.AuthRequired, tc.auth)
+            }
+            if p.User != tc.user {
+                t.Errorf("user = %q, want %q", p.User, tc.user)
+            }
+        })
+    }
+}
+
+func TestDetectSOCKS5Unreachable(t *testing.T) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    address := l.Addr().String()
+    l.Close()
+    _, attempts, ok := testScanner(t).detect(address)
+    if ok {
+        t.Fatal("detect found a proxy on a closed port")
+    }
+    if r := (Result{Attempts: attempts}); !r.unreachable() {
+        t.Errorf("attempts %v not reported as unreachable", attempts)
+    }
+}
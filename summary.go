@@ -0,0 +1,340 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// scanStats collects per-protocol latencies from worker goroutines
+type scanStats struct {
+    mu        sync.Mutex
+    start     time.Time
+    tasks     int
+    total     int // tasks in the current pass
+    latencies map[string][]time.Duration
+    lastFound time.Time                 // last result recorded, or the start of the pass
+    failures  map[string]map[string]int // protocol → failure class → count
+    auth      map[string]int            // protocol → servers that want auth we can't do
+}
+
+func newScanStats() *scanStats {
+    return &scanStats{
+        start:     time.Now(),
+        latencies: make(map[string][]time.Duration),
+        failures:  make(map[string]map[string]int),
+        auth:      make(map[string]int),
+    }
+}
+
+// task counts one tested IP:port
+func (st *scanStats) task() {
+    st.mu.Lock()
+    st.tasks++
+    st.mu.Unlock()
+}
+
+// beginPass resets the progress counters for a new pass over total tasks
+func (st *scanStats) beginPass(total int) {
+    st.mu.Lock()
+    st.tasks = 0
+    st.total = total
+    st.lastFound = time.Now()
+    st.mu.Unlock()
+}
+
+// progress returns tasks done and total in the current pass
+func (st *scanStats) progress() (int, int) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.tasks, st.total
+}
+
+// record stores the latency of a detected proxy
+func (st *scanStats) record(protocol string, latency time.Duration) {
+    st.mu.Lock()
+    st.latencies[protocol] = append(st.latencies[protocol], latency)
+    st.lastFound = time.Now()
+    st.mu.Unlock()
+}
+
+// recordAuth counts a server that speaks protocol but wants auth we can't
+// do; these are recorded but not counted as found
+func (st *scanStats) recordAuth(protocol string) {
+    st.mu.Lock()
+    st.auth[protocol]++
+    st.lastFound = time.Now()
+    st.mu.Unlock()
+}
+
+// heartbeat logs a line every interval in which nothing was found, so
+// long quiet stretches of a scan still show it is alive, until done is
+// closed
+func (st *scanStats) heartbeat(interval time.Duration, logLevel string, done <-chan struct{}) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-done:
+            return
+        case <-ticker.C:
+        }
+        st.mu.Lock()
+        tasks, total, quiet := st.tasks, st.total, time.Since(st.lastFound)
+        elapsed := time.Since(st.start)
+        st.mu.Unlock()
+        if quiet >= interval {
+            logPrint("info", logLevel, "[*] Still scanning: %s elapsed, %d of %d tasks started, nothing found for %s\n",
+                elapsed.Round(time.Second), tasks, total, quiet.Round(time.Second))
+        }
+    }
+}
+
+// fail counts a failed protocol check by failure class
+func (st *scanStats) fail(protocol string, err error) {
+    class := failureClass(err)
+    st.mu.Lock()
+    if st.failures[protocol] == nil {
+        st.failures[protocol] = make(map[string]int)
+    }
+    st.failures[protocol][class]++
+    st.mu.Unlock()
+}
+
+// failureClass buckets a check error for the summary
+func failureClass(err error) string {
+    var ne net.Error
+    switch {
+    case errors.As(err, &ne) && ne.Timeout():
+        return "timeout"
+    case errors.Is(err, syscall.ECONNREFUSED):
+        return "refused"
+    case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+        return "closed"
+    }
+    return "protocol"
+}
+
+// ProtocolSummary describes the proxies found for one protocol
+type ProtocolSummary struct {
+    Count  int     `json:"count"`
+    MeanMs float64 `json:"mean_ms"`
+    P50Ms  float64 `json:"p50_ms"`
+    P90Ms  float64 `json:"p90_ms"`
+    P99Ms  float64 `json:"p99_ms"`
+}
+
+// latencyBuckets are the upper bounds of the -timeout-distribution
+// histogram; a last bucket holds everything slower
+var latencyBuckets = []time.Duration{
+    50 * time.Millisecond,
+    100 * time.Millisecond,
+    200 * time.Millisecond,
+    400 * time.Millisecond,
+    800 * time.Millisecond,
+    1600 * time.Millisecond,
+    3200 * time.Millisecond,
+    6400 * time.Millisecond,
+}
+
+// HistogramBucket counts the successful checks faster than UpToMs, or,
+// in the last bucket (UpToMs 0), all slower ones
+type HistogramBucket struct {
+    UpToMs    float64        `json:"up_to_ms,omitempty"`
+    Count     int            `json:"count"`
+    Protocols map[string]int `json:"protocols"`
+}
+
+// histogram buckets latencies per protocol
+func histogram(latencies map[string][]time.Duration) []HistogramBucket {
+    buckets := make([]HistogramBucket, len(latencyBuckets)+1)
+    for i := range buckets {
+        buckets[i].Protocols = make(map[string]int)
+        if i < len(latencyBuckets) {
+            buckets[i].UpToMs = ms(latencyBuckets[i])
+        }
+    }
+    for protocol, lat := range latencies {
+        for _, d := range lat {
+            i := sort.Search(len(latencyBuckets), func(i int) bool { return d < latencyBuckets[i] })
+            buckets[i].Count++
+            buckets[i].Protocols[protocol]++
+        }
+    }
+    return buckets
+}
+
+// Summary is the end-of-scan report
+type Summary struct {
+    Tasks       int                        `json:"tasks"`
+    Found       int                        `json:"found"`
+    ElapsedSec  float64                    `json:"elapsed_sec"`
+    TasksPerSec float64                    `json:"tasks_per_sec"`
+    Protocols   map[string]ProtocolSummary `json:"protocols"`
+    Failures    map[string]map[string]int  `json:"failures,omitempty"`
+    AuthNeeded  map[string]int             `json:"auth_required,omitempty"`
+    TFO         bool                       `json:"tcp_fast_open,omitempty"`
+    OutputHash  string                     `json:"output_sha256,omitempty"`
+    Histogram   []HistogramBucket          `json:"latency_histogram,omitempty"`
+    Run         string                     `json:"run,omitempty"`
+}
+
+func (st *scanStats) summary() Summary {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    sum := Summary{
+        Tasks:      st.tasks,
+        ElapsedSec: time.Since(st.start).Seconds(),
+        Protocols:  make(map[string]ProtocolSummary),
+        Failures:   make(map[string]map[string]int),
+    }
+    if len(st.auth) > 0 {
+        sum.AuthNeeded = make(map[string]int, len(st.auth))
+        for protocol, n := range st.auth {
+            sum.AuthNeeded[protocol] = n
+        }
+    }
+    for protocol, classes := range st.failures {
+        sum.Failures[protocol] = make(map[string]int, len(classes))
+        for class, n := range classes {
+            sum.Failures[protocol][class] = n
+        }
+    }
+    if sum.ElapsedSec > 0 {
+        sum.TasksPerSec = float64(sum.Tasks) / sum.ElapsedSec
+    }
+    for _, protocol := range []string{"HTTP", "SOCKS4", "SOCKS5"} {
+        sum.Protocols[protocol] = ProtocolSummary{}
+    }
+    for protocol, lat := range st.latencies {
+        sorted := append([]time.Duration(nil), lat...)
+        sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+        var total time.Duration
+        for _, d := range sorted {
+            total += d
+        }
+        ps := ProtocolSummary{Count: len(sorted)}
+        if len(sorted) > 0 {
+            ps.MeanMs = ms(total / time.Duration(len(sorted)))
+            ps.P50Ms = ms(percentile(sorted, 50))
+            ps.P90Ms = ms(percentile(sorted, 90))
+            ps.P99Ms = ms(percentile(sorted, 99))
+        }
+        sum.Protocols[protocol] = ps
+        sum.Found += ps.Count
+    }
+    sum.Histogram = histogram(st.latencies)
+    return sum
+}
+
+// percentile returns the nearest-rank p-th percentile of a sorted, non-empty slice
+func percentile(sorted []time.Duration, p int) time.Duration {
+    rank := (p*len(sorted) + 99) / 100
+    if rank < 1 {
+        rank = 1
+    }
+    return sorted[rank-1]
+}
+
+func ms(d time.Duration) float64 {
+    return float64(d.Microseconds()) / 1000
+}
+
+// String renders the summary for the terminal
+func (sum Summary) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "[*] Scanned %d targets in %.1fs (%.0f/s), found %d proxies", sum.Tasks, sum.ElapsedSec, sum.TasksPerSec, sum.Found)
+    if sum.TFO {
+        b.WriteString(" with TCP Fast Open")
+    }
+    b.WriteString("\n")
+    protocols := make([]string, 0, len(sum.Protocols))
+    for protocol := range sum.Protocols {
+        protocols = append(protocols, protocol)
+    }
+    sort.Strings(protocols)
+    for _, protocol := range protocols {
+        ps := sum.Protocols[protocol]
+        if ps.Count == 0 {
+            fmt.Fprintf(&b, "    %-7s %5d\n", protocol, 0)
+            continue
+        }
+        fmt.Fprintf(&b, "    %-7s %5d  mean %.0fms  p50 %.0fms  p90 %.0fms  p99 %.0fms\n",
+            protocol, ps.Count, ps.MeanMs, ps.P50Ms, ps.P90Ms, ps.P99Ms)
+    }
+    protocols = protocols[:0]
+    for protocol := range sum.AuthNeeded {
+        protocols = append(protocols, protocol)
+    }
+    sort.Strings(protocols)
+    for _, protocol := range protocols {
+        fmt.Fprintf(&b, "    %-7s auth required %d (not counted as found)\n", protocol, sum.AuthNeeded[protocol])
+    }
+    protocols = protocols[:0]
+    for protocol := range sum.Failures {
+        protocols = append(protocols, protocol)
+    }
+    sort.Strings(protocols)
+    for _, protocol := range protocols {
+        classes := make([]string, 0, len(sum.Failures[protocol]))
+        for class, n := range sum.Failures[protocol] {
+            classes = append(classes, fmt.Sprintf("%s %d", class, n))
+        }
+        sort.Strings(classes)
+        fmt.Fprintf(&b, "    %-7s failed: %s\n", protocol, strings.Join(classes, ", "))
+    }
+    if len(sum.Histogram) > 0 && sum.Found > 0 {
+        sum.writeHistogram(&b)
+    }
+    if sum.OutputHash != "" {
+        fmt.Fprintf(&b, "    output sha256 %s\n", sum.OutputHash)
+    }
+    return b.String()
+}
+
+// writeHistogram renders the latency histogram as a table with one count
+// column per protocol that found anything
+func (sum Summary) writeHistogram(b *strings.Builder) {
+    var protocols []string
+    for protocol, ps := range sum.Protocols {
+        if ps.Count > 0 {
+            protocols = append(protocols, protocol)
+        }
+    }
+    sort.Strings(protocols)
+    fmt.Fprintf(b, "    %-9s %6s %5s", "latency", "all", "cum")
+    for _, protocol := range protocols {
+        fmt.Fprintf(b, " %7s", protocol)
+    }
+    b.WriteString("\n")
+    cum := 0
+    for i, bucket := range sum.Histogram {
+        label := fmt.Sprintf("<%s", latencyBuckets[min(i, len(latencyBuckets)-1)])
+        if bucket.UpToMs == 0 {
+            label = fmt.Sprintf(">=%s", latencyBuckets[len(latencyBuckets)-1])
+        }
+        cum += bucket.Count
+        fmt.Fprintf(b, "    %-9s %6d %4.0f%%", label, bucket.Count, 100*float64(cum)/float64(sum.Found))
+        for _, protocol := range protocols {
+            fmt.Fprintf(b, " %7d", bucket.Protocols[protocol])
+        }
+        b.WriteString("\n")
+    }
+}
+
+// writeSummary saves the summary as indented JSON
+func writeSummary(path string, sum Summary) error {
+    data, err := json.MarshalIndent(sum, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, append(data, '\n'), 0644)
+}
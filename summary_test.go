@@ -0,0 +1,24 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestSummaryAuthRequiredNotFound(t *testing.T) {
+    st := newScanStats()
+    st.record("SOCKS5", 20*time.Millisecond)
+    st.recordAuth("SOCKS5")
+    st.recordAuth("SOCKS5")
+    sum := st.summary()
+    if sum.Found != 1 || sum.Protocols["SOCKS5"].Count != 1 {
+        t.Errorf("found %d (SOCKS5 %d), want 1", sum.Found, sum.Protocols["SOCKS5"].Count)
+    }
+    if sum.AuthNeeded["SOCKS5"] != 2 {
+        t.Errorf("auth required = %v, want SOCKS5 2", sum.AuthNeeded)
+    }
+    if !strings.Contains(sum.String(), "SOCKS5  auth required 2") {
+        t.Errorf("summary does not list the auth-required servers:\n%s", sum)
+    }
+}
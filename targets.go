@@ -0,0 +1,166 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync/atomic"
+
+    "golang.org/x/net/idna"
+)
+
+// judgeWarnAfter is how many proxies must reach a test target, all
+// failing, before it is reported as a likely culprit
+const judgeWarnAfter = 50
+
+// testTarget is one destination the checks ask a proxy to reach
+type testTarget struct {
+    Host string // hostname or IP
+    Port int
+    URL  string // absolute URL sent to HTTP proxies
+
+    attempts  atomic.Int64 // proxies that answered a request for this target
+    successes atomic.Int64
+    warned    atomic.Bool
+}
+
+// hostHeader is the Host header value for the target
+func (t *testTarget) hostHeader() string {
+    if t.Port == 80 {
+        return t.Host
+    }
+    return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// path returns the request path of t's URL, for origin-form requests
+// sent through a tunnel
+func (t *testTarget) path() string {
+    if u, err := url.Parse(t.URL); err == nil {
+        return u.RequestURI()
+    }
+    return "/"
+}
+
+// targetPool hands out test targets round-robin so no single flaky
+// target decides the outcome of a whole scan
+type targetPool struct {
+    targets []*testTarget
+    next    atomic.Uint64
+}
+
+// parseTestURLs parses a comma-separated list of http:// URLs
+func parseTestURLs(s string) (*targetPool, error) {
+    pool := &targetPool{}
+    for _, raw := range strings.Split(s, ",") {
+        raw = strings.TrimSpace(raw)
+        if raw == "" {
+            continue
+        }
+        u, err := url.Parse(raw)
+        if err != nil {
+            return nil, err
+        }
+        if u.Scheme != "http" || u.Hostname() == "" {
+            return nil, fmt.Errorf("test URL %q must be http://host/...", raw)
+        }
+        port := 80
+        if u.Port() != "" {
+            if port, err = strconv.Atoi(u.Port()); err != nil {
+                return nil, fmt.Errorf("invalid port in %q", raw)
+            }
+        }
+        if u.Path == "" {
+            u.Path = "/"
+        }
+        host, err := asciiHost(u.Hostname())
+        if err != nil {
+            return nil, fmt.Errorf("test URL %q: %v", raw, err)
+        }
+        if host != u.Hostname() {
+            u.Host = host
+            if u.Port() != "" {
+                u.Host = net.JoinHostPort(host, u.Port())
+            }
+        }
+        pool.targets = append(pool.targets, &testTarget{Host: host, Port: port, URL: u.String()})
+    }
+    if len(pool.targets) == 0 {
+        return nil, fmt.Errorf("no test URLs given")
+    }
+    return pool, nil
+}
+
+// parseTestIPs parses a comma-separated list of "host:port" (or bare
+// host, port 80) targets for SOCKS4. Hostnames are resolved through the
+// scanner's DNS cache at check time.
+func parseTestIPs(s string) (*targetPool, error) {
+    pool := &targetPool{}
+    for _, raw := range strings.Split(s, ",") {
+        raw = strings.TrimSpace(raw)
+        if raw == "" {
+            continue
+        }
+        host, portStr, err := net.SplitHostPort(raw)
+        if err != nil {
+            host, portStr = raw, "80"
+        }
+        if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+            return nil, fmt.Errorf("test IP %q must be IPv4", raw)
+        }
+        port, err := strconv.Atoi(portStr)
+        if err != nil {
+            return nil, fmt.Errorf("invalid port in %q", raw)
+        }
+        if host, err = asciiHost(host); err != nil {
+            return nil, fmt.Errorf("test IP %q: %v", raw, err)
+        }
+        pool.targets = append(pool.targets, &testTarget{
+            Host: host,
+            Port: port,
+            URL:  "http://" + net.JoinHostPort(host, portStr) + "/",
+        })
+    }
+    if len(pool.targets) == 0 {
+        return nil, fmt.Errorf("no test IPs given")
+    }
+    return pool, nil
+}
+
+// asciiHost converts an internationalized hostname to punycode, the form
+// SOCKS5 (ATYP 0x03, one length byte) and the Host header need. IP
+// literals pass through unchanged.
+func asciiHost(host string) (string, error) {
+    if net.ParseIP(host) != nil {
+        return host, nil
+    }
+    ascii, err := idna.Lookup.ToASCII(host)
+    if err != nil {
+        return "", fmt.Errorf("invalid hostname %q: %v", host, err)
+    }
+    if len(ascii) > 255 {
+        return "", fmt.Errorf("hostname %q is %d bytes encoded, SOCKS5 allows at most 255", host, len(ascii))
+    }
+    return ascii, nil
+}
+
+// pick returns the next target in rotation
+func (p *targetPool) pick() *testTarget {
+    n := p.next.Add(1) - 1
+    return p.targets[n%uint64(len(p.targets))]
+}
+
+// report records whether a proxy that answered managed to reach t, and
+// warns once if t keeps failing while proxies are clearly responding
+func (p *targetPool) report(t *testTarget, ok bool) {
+    attempts := t.attempts.Add(1)
+    if ok {
+        t.successes.Add(1)
+        return
+    }
+    if attempts >= judgeWarnAfter && t.successes.Load() == 0 && !t.warned.Swap(true) {
+        log.Printf("Test target %s failed for all %d proxies that answered; the target may be down or blocking, not the proxies", t.URL, attempts)
+    }
+}
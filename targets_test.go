@@ -0,0 +1,147 @@
+package main
+
+import (
+    "net"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestNextIP(t *testing.T) {
+    tests := []struct {
+        in   net.IP
+        want string // "" for nil
+    }{
+        {net.ParseIP("10.0.0.1"), "10.0.0.2"},
+        {net.ParseIP("10.0.0.255"), "10.0.1.0"},
+        {net.IP{10, 0, 0, 1}, "10.0.0.2"},
+        {net.ParseIP("2001:db8::1"), "2001:db8::2"},
+        {net.ParseIP("2001:db8::ffff"), "2001:db8::1:0"},
+        {net.ParseIP("2001:db8::ffff:ffff"), "2001:db8::1:0:0"},
+        {net.IP{1, 2, 3}, ""},
+        {nil, ""},
+    }
+    for _, tc := range tests {
+        got := nextIP(append(net.IP(nil), tc.in...))
+        if tc.want == "" {
+            if got != nil {
+                t.Errorf("nextIP(%v) = %v, want nil", tc.in, got)
+            }
+            continue
+        }
+        if got.String() != tc.want {
+            t.Errorf("nextIP(%v) = %v, want %s", tc.in, got, tc.want)
+        }
+    }
+}
+
+func TestExpandCIDRIPv6(t *testing.T) {
+    // A 16-byte network straight from ParseCIDR, not via expandTarget
+    _, ipnet, err := net.ParseCIDR("2001:db8::1:ff/126")
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"2001:db8::1:fc", "2001:db8::1:fd", "2001:db8::1:fe", "2001:db8::1:ff"}
+    if got := expandCIDR(ipnet); !reflect.DeepEqual(got, want) {
+        t.Errorf("expandCIDR(%v) = %v, want %v", ipnet, got, want)
+    }
+}
+
+func TestExpandTargets(t *testing.T) {
+    tests := []struct {
+        line string
+        want []string // nil for an invalid line
+    }{
+        {"10.0.0.1", []string{"10.0.0.1"}},
+        {"10.0.0.0/30", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+        {"10.0.0.254-10.0.1.1", []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}},
+        {"2001:db8::1", []string{"2001:db8::1"}},
+        {" 2001:db8::/127 ", []string{"2001:db8::", "2001:db8::1"}},
+        {"2001:db8::fffe-2001:db8::1:1", []string{"2001:db8::fffe", "2001:db8::ffff", "2001:db8::1:0", "2001:db8::1:1"}},
+        {"2001:db8::5 - 2001:db8::5", []string{"2001:db8::5"}},
+        {"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"}},
+        {"2001:db8::2-2001:db8::1", nil},
+        {"10.0.0.1-2001:db8::1", nil},
+        {"2001:db8::zz", nil},
+    }
+    for _, tc := range tests {
+        got, bad := expandTargets([]string{tc.line})
+        if tc.want == nil {
+            if len(got) != 0 || len(bad) != 1 {
+                t.Errorf("expandTargets(%q) = %v, bad %v; want the line rejected", tc.line, got, bad)
+            }
+            continue
+        }
+        if len(bad) != 0 || !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("expandTargets(%q) = %v, bad %v; want %v", tc.line, got, bad, tc.want)
+        }
+    }
+}
+
+// TestExpandCIDRTopOfSpace covers blocks that end at the last address,
+// where counting up wraps to all-zeros, which the block would contain
+// for /0 and loop forever
+func TestExpandCIDRTopOfSpace(t *testing.T) {
+    tests := []struct {
+        cidr  string
+        first string
+        last  string
+        n     int
+    }{
+        {"255.255.255.254/31", "255.255.255.254", "255.255.255.255", 2},
+        {"255.255.255.0/24", "255.255.255.0", "255.255.255.255", 256},
+        {"255.255.255.255/32", "255.255.255.255", "255.255.255.255", 1},
+        {"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", 2},
+    }
+    for _, tc := range tests {
+        _, ipnet, err := net.ParseCIDR(tc.cidr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        done := make(chan []string, 1)
+        go func() { done <- expandCIDR(ipnet) }()
+        var got []string
+        select {
+        case got = <-done:
+        case <-time.After(5 * time.Second):
+            t.Fatalf("expandCIDR(%s) did not return", tc.cidr)
+        }
+        if len(got) != tc.n || got[0] != tc.first || got[len(got)-1] != tc.last {
+            t.Errorf("expandCIDR(%s) = %d addresses %v..%v, want %d from %s to %s", tc.cidr, len(got), got[0], got[len(got)-1], tc.n, tc.first, tc.last)
+        }
+    }
+}
+
+func TestTargetSize(t *testing.T) {
+    tests := []struct {
+        line string
+        want string
+    }{
+        {"10.0.0.1", "1"},
+        {"10.0.0.0/24", "256"},
+        {"0.0.0.0/0", "4294967296"},
+        {"2001:db8::/64", "18446744073709551616"},
+        {"::/0", "340282366920938463463374607431768211456"},
+        {"10.0.0.254-10.0.1.1", "4"},
+        {"10.0.1.1-10.0.0.1", "0"},
+        {"2001:db8::1-2001:db8::2", "2"},
+        {"2001:db8::-2001:db8::ffff:ffff:ffff:ffff", "18446744073709551616"},
+        {"2001:db8::2-2001:db8::1", "0"},
+        {"bogus", "0"},
+    }
+    for _, tc := range tests {
+        if got := targetSize(tc.line).String(); got != tc.want {
+            t.Errorf("targetSize(%q) = %s, want %s", tc.line, got, tc.want)
+        }
+    }
+    // Agrees with the expansion for what fits in memory
+    for _, line := range []string{"192.0.2.0/28", "2001:db8::/124", "192.0.2.250-192.0.3.5", "2001:db8::fff0-2001:db8::1:f"} {
+        ips, err := expandTarget(line)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := targetSize(line).Int64(); got != int64(len(ips)) {
+            t.Errorf("targetSize(%q) = %d, expandTarget gives %d", line, got, len(ips))
+        }
+    }
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "net"
+    "strconv"
+    "sync/atomic"
+    "time"
+)
+
+// Task is one IP:port to scan
+type Task struct {
+    IP   string
+    Port int
+    CIDR string   // input line the IP came from, set with -per-cidr-concurrency or -min-success-rate
+    Tags []string // -targets-jsonl metadata, copied onto the result
+}
+
+// Address formats the task as host:port
+func (t Task) Address() string {
+    return net.JoinHostPort(t.IP, strconv.Itoa(t.Port))
+}
+
+// Result is a worker's outcome for one Task. On success Proxy holds what
+// was found; otherwise Err says why nothing was. Attempts lists the checks
+// that failed either way, so a host found as SOCKS5 still shows how its
+// HTTP check went.
+type Result struct {
+    Task
+    Proxy    Proxy
+    Attempts []Attempt
+    Err      error
+}
+
+// Attempt is one failed protocol check
+type Attempt struct {
+    Protocol string
+    Err      error
+}
+
+// hostState is per-IP state shared by the workers of one pass
+type hostState struct {
+    matched   atomic.Bool  // -first-match-per-host: a port already yielded a result
+    spent     atomic.Int64 // -max-host-time: nanoseconds spent on this host
+    abandoned atomic.Bool  // -max-host-time exceeded, remaining ports are skipped
+}
+
+// cidrGates bounds how many tasks of one input CIDR run at once
+// (-per-cidr-concurrency). A nil cidrGates never blocks.
+type cidrGates map[string]chan struct{}
+
+// newCIDRGates makes an n-slot gate for every CIDR in cidrOf
+func newCIDRGates(cidrOf map[string]string, n int) cidrGates {
+    g := make(cidrGates)
+    for _, cidr := range cidrOf {
+        if g[cidr] == nil {
+            g[cidr] = make(chan struct{}, n)
+        }
+    }
+    return g
+}
+
+// acquire takes a slot for cidr and returns the function releasing it
+func (g cidrGates) acquire(cidr string) func() {
+    sem := g[cidr]
+    if sem == nil {
+        return func() {}
+    }
+    sem <- struct{}{}
+    return func() { <-sem }
+}
+
+// cidrRates abandons the rest of an input CIDR once it has had
+// minAttempts tasks and less than minRate percent of them found
+// something (-min-success-rate). A nil cidrRates never abandons.
+type cidrRates struct {
+    minRate     float64
+    minAttempts int64
+    counts      map[string]*cidrCount
+}
+
+// cidrCount is the running tally of one CIDR
+type cidrCount struct {
+    attempts  atomic.Int64
+    successes atomic.Int64
+    abandoned atomic.Bool
+}
+
+// newCIDRRates makes a tally for every CIDR in cidrOf
+func newCIDRRates(cidrOf map[string]string, minRate float64, minAttempts int) *cidrRates {
+    r := &cidrRates{minRate: minRate, minAttempts: int64(minAttempts), counts: make(map[string]*cidrCount)}
+    for _, cidr := range cidrOf {
+        if r.counts[cidr] == nil {
+            r.counts[cidr] = &cidrCount{}
+        }
+    }
+    return r
+}
+
+// abandoned reports whether the remaining tasks of cidr are skipped
+func (r *cidrRates) abandoned(cidr string) bool {
+    if r == nil || r.counts[cidr] == nil {
+        return false
+    }
+    return r.counts[cidr].abandoned.Load()
+}
+
+// observe counts a finished task of cidr and abandons the CIDR when it
+// falls below the rate
+func (r *cidrRates) observe(cidr string, found bool) {
+    if r == nil || r.counts[cidr] == nil {
+        return
+    }
+    c := r.counts[cidr]
+    if found {
+        c.successes.Add(1)
+    }
+    attempts := c.attempts.Add(1)
+    if attempts < r.minAttempts {
+        return
+    }
+    rate := 100 * float64(c.successes.Load()) / float64(attempts)
+    if rate < r.minRate && !c.abandoned.Swap(true) {
+        log.Printf("Abandoning %s: %.2f%% success over %d tasks, under -min-success-rate %g%%", cidr, rate, attempts, r.minRate)
+    }
+}
+
+// connectError is a check's failure to reach the target at all
+type connectError struct {
+    err error
+}
+
+func (e *connectError) Error() string {
+    return "connect: " + e.err.Error()
+}
+
+func (e *connectError) Unwrap() error {
+    return e.err
+}
+
+var (
+    errClosed  = errors.New("port closed")
+    errNoProxy = errors.New("no check passed")
+
+    errCustomRejected = errors.New("custom check rejected")
+)
+
+// unreachable reports whether the task failed without ever connecting:
+// a closed port in portscan mode, or a check that could not dial
+func (r Result) unreachable() bool {
+    if r.Err == errClosed {
+        return true
+    }
+    for _, a := range r.Attempts {
+        var ce *connectError
+        if errors.As(a.Err, &ce) {
+            return true
+        }
+    }
+    return false
+}
+
+// scanTask runs the checks for one task: a plain connect in portscan
+// mode, otherwise the protocol cascade with an optional banner fallback
+func (s *Scanner) scanTask(t Task) Result {
+    address := t.Address()
+    res := Result{Task: t, Proxy: Proxy{Address: address, Tags: t.Tags}}
+    if s.Mode == "portscan" {
+        start := time.Now()
+        if !s.isOpen(address, s.OpenTimeout) {
+            res.Err = errClosed
+            return res
+        }
+        res.Proxy.Protocol, res.Proxy.Latency = "OPEN", time.Since(start)
+        return res
+    }
+    p, attempts, ok := s.detect(address)
+    res.Attempts = attempts
+    if ok {
+        res.Proxy = p
+        res.Proxy.Tags = t.Tags
+        return res
+    }
+    if s.GrabBanner > 0 {
+        if banner, ok := s.grabBanner(address, s.GrabBanner); ok {
+            res.Proxy.Protocol, res.Proxy.Banner = "BANNER", banner
+            return res
+        }
+    }
+    // Every check connected but none recognised the protocol: something
+    // is listening, maybe a raw TCP tunnel
+    if s.ReportOpenTCP && len(attempts) > 0 && !res.unreachable() {
+        res.Proxy.Protocol = "OPEN-TCP"
+        return res
+    }
+    res.Err = errNoProxy
+    return res
+}
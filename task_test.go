@@ -0,0 +1,121 @@
+package main
+
+import (
+    "context"
+    "io"
+    "net"
+    "reflect"
+    "strconv"
+    "sync"
+    "syscall"
+    "testing"
+    "time"
+)
+
+// scriptDialer hands out in-memory connections whose far end is served by
+// serve, recording every address dialed. A nil serve refuses every dial.
+type scriptDialer struct {
+    serve func(conn net.Conn)
+    mu    sync.Mutex
+    dials []string
+}
+
+func (d *scriptDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+    return d.DialContext(context.Background(), network, address)
+}
+
+func (d *scriptDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+    d.mu.Lock()
+    d.dials = append(d.dials, address)
+    d.mu.Unlock()
+    if d.serve == nil {
+        return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+    }
+    client, server := net.Pipe()
+    go func() {
+        defer server.Close()
+        server.SetDeadline(time.Now().Add(5 * time.Second))
+        d.serve(server)
+    }()
+    return client, nil
+}
+
+// hangup reads the first request and hangs up, like a service that
+// speaks no proxy protocol
+func hangup(conn net.Conn) {
+    conn.Read(make([]byte, 512))
+}
+
+// greeter sends a banner on connect and hangs up
+func greeter(conn net.Conn) {
+    go io.Copy(io.Discard, conn)
+    conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+}
+
+func TestScanTask(t *testing.T) {
+    task := Task{IP: "192.0.2.1", Port: 1080, Tags: []string{"dc"}}
+    tests := []struct {
+        name     string
+        serve    func(net.Conn)
+        setup    func(s *Scanner)
+        protocol string // want Proxy.Protocol, "" for an error
+        err      error
+        down     bool // want Result.unreachable
+    }{
+        {"portscan open", hangup, func(s *Scanner) { s.Mode = "portscan" }, "OPEN", nil, false},
+        {"portscan closed", nil, func(s *Scanner) { s.Mode = "portscan" }, "", errClosed, true},
+        {"refused", nil, nil, "", errNoProxy, true},
+        {"no proxy", hangup, nil, "", errNoProxy, false},
+        {"open tcp", hangup, func(s *Scanner) { s.ReportOpenTCP = true }, "OPEN-TCP", nil, false},
+        {"banner", greeter, func(s *Scanner) { s.GrabBanner = 64 }, "BANNER", nil, false},
+    }
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            s := testScanner(t)
+            s.Timeout = 1
+            s.OpenTimeout = time.Second
+            s.Dialer = &scriptDialer{serve: tc.serve}
+            if tc.setup != nil {
+                tc.setup(s)
+            }
+            res := s.scanTask(task)
+            if res.Task.Address() != task.Address() {
+                t.Errorf("result for %s, want %s", res.Task.Address(), task.Address())
+            }
+            if res.Err != tc.err {
+                t.Fatalf("err = %v, want %v", res.Err, tc.err)
+            }
+            if res.Err == nil {
+                if res.Proxy.Protocol != tc.protocol || res.Proxy.Address != task.Address() {
+                    t.Errorf("proxy = %s %s, want %s %s", res.Proxy.Address, res.Proxy.Protocol, task.Address(), tc.protocol)
+                }
+                if !reflect.DeepEqual(res.Proxy.Tags, task.Tags) {
+                    t.Errorf("tags = %v, want %v", res.Proxy.Tags, task.Tags)
+                }
+            }
+            if got := res.unreachable(); got != tc.down {
+                t.Errorf("unreachable = %v, want %v (attempts %v)", got, tc.down, res.Attempts)
+            }
+        })
+    }
+}
+
+func TestScanTaskAttemptsBeforeMatch(t *testing.T) {
+    srv := &socks5Server{}
+    address := srv.listen(t)
+    host, port, _ := net.SplitHostPort(address)
+    portNum, _ := strconv.Atoi(port)
+    res := testScanner(t).scanTask(Task{IP: host, Port: portNum})
+    if res.Err != nil || res.Proxy.Protocol != "SOCKS5" {
+        t.Fatalf("scanTask = %s %v, want SOCKS5", res.Proxy.Protocol, res.Err)
+    }
+    // The default order tries HTTP and SOCKS4 first; both fail on a SOCKS5
+    // server and are kept as attempts
+    var tried []string
+    for _, a := range res.Attempts {
+        tried = append(tried, a.Protocol)
+    }
+    if !reflect.DeepEqual(tried, []string{"HTTP", "SOCKS4"}) {
+        t.Errorf("attempts = %v, want HTTP and SOCKS4", tried)
+    }
+}
@@ -0,0 +1,15 @@
+package main
+
+import "syscall"
+
+// tcpFastOpenConnect is TCP_FASTOPEN_CONNECT (Linux 4.11+), which lets
+// connect return at once and carries the first write in the SYN
+const tcpFastOpenConnect = 30
+
+// tfoControl enables TCP Fast Open on dialed sockets. Kernels without
+// support reject the option, and the dial proceeds as a normal handshake.
+func tfoControl(network, address string, c syscall.RawConn) error {
+    return c.Control(func(fd uintptr) {
+        syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+    })
+}
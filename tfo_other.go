@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// tfoControl is a no-op where TCP Fast Open is not wired up; -tfo dials
+// with a normal handshake
+func tfoControl(network, address string, c syscall.RawConn) error {
+    return nil
+}
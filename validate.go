@@ -0,0 +1,169 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math/big"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// validateSources are the target and port inputs -validate checks: the
+// -cidr and -ports files and the inline -targets, -port-list and
+// -services flags, read under the same rules as a scan
+type validateSources struct {
+    CIDRFile, PortsFile         string
+    Targets, PortList, Services string
+}
+
+// runValidate checks the target and port inputs without scanning: every
+// invalid line is reported with its line number, followed by the totals.
+// Sizes come from the prefix lengths and range bounds, so a /8 or an
+// IPv6 /64 is counted without being expanded. It returns the process
+// exit code, using the input exit codes when a file is missing, has no
+// entries or has any invalid line.
+func runValidate(src validateSources, w io.Writer) int {
+    code := 0
+    worse := func(c int) {
+        if c > code {
+            code = c
+        }
+    }
+
+    ips := new(big.Int)
+    countIPs := func(line string) error {
+        n, err := sizeTarget(line)
+        if err != nil {
+            return err
+        }
+        ips.Add(ips, n)
+        return nil
+    }
+    var ipSources []string
+    if src.Targets == "" || src.CIDRFile != "Cidr.txt" {
+        worse(validateInput(src.CIDRFile, w, countIPs))
+        ipSources = append(ipSources, src.CIDRFile)
+    }
+    if src.Targets != "" {
+        worse(validateList("-targets", src.Targets, w, countIPs))
+        ipSources = append(ipSources, "-targets")
+    }
+
+    var ports []int
+    countPorts := func(line string) error {
+        start, end, err := parsePortLine(line)
+        if err != nil {
+            return fmt.Errorf("not a port or start-end port range: %v", err)
+        }
+        for p := start; p <= end; p++ {
+            ports = append(ports, p)
+        }
+        return nil
+    }
+    var portSources []string
+    if _, err := os.Stat(src.PortsFile); src.PortsFile != "Ports.txt" || (src.PortList == "" && (src.Services == "" || err == nil)) {
+        worse(validateInput(src.PortsFile, w, countPorts))
+        portSources = append(portSources, src.PortsFile)
+    }
+    if src.PortList != "" {
+        worse(validateList("-port-list", src.PortList, w, countPorts))
+        portSources = append(portSources, "-port-list")
+    }
+    if src.Services != "" {
+        svc, err := servicePorts(src.Services)
+        if err != nil {
+            fmt.Fprintf(w, "-services: invalid %q: %v\n", src.Services, err)
+            worse(exitInputInvalid)
+        }
+        ports = mergePorts(ports, svc)
+        portSources = append(portSources, "-services")
+    }
+
+    tasks := new(big.Int).Mul(ips, big.NewInt(int64(len(ports))))
+    fmt.Fprintf(w, "%s: %s IPs\n%s: %d ports\n%s tasks\n", strings.Join(ipSources, " + "), ips, strings.Join(portSources, " + "), len(ports), tasks)
+    if ips.Sign() == 0 || len(ports) == 0 {
+        worse(exitInputEmpty)
+    }
+    return code
+}
+
+// validateList runs parse on every entry of a comma-separated flag value,
+// printing failures as "flag:N: ..." like validateInput
+func validateList(flagName, list string, w io.Writer, parse func(line string) error) int {
+    items := splitList(list)
+    if len(items) == 0 {
+        fmt.Fprintf(w, "%s: no entries\n", flagName)
+        return exitInputEmpty
+    }
+    code := 0
+    for n, item := range items {
+        if err := parse(item); err != nil {
+            fmt.Fprintf(w, "%s:%d: invalid %q: %v\n", flagName, n+1, item, err)
+            code = exitInputInvalid
+        }
+    }
+    return code
+}
+
+// validateInput runs parse on every entry of source, printing failures as
+// "source:N: ...". It returns 0 or an input exit code.
+func validateInput(source string, w io.Writer, parse func(line string) error) int {
+    var r io.Reader
+    if isURL(source) {
+        resp, err := (&http.Client{Timeout: inputFetchTimeout}).Get(source)
+        if err != nil {
+            fmt.Fprintf(w, "%s: cannot fetch: %v\n", source, err)
+            return exitInputMissing
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            fmt.Fprintf(w, "%s: cannot fetch: HTTP %s\n", source, resp.Status)
+            return exitInputMissing
+        }
+        r = resp.Body
+    } else {
+        f, err := os.Open(source)
+        if err != nil {
+            fmt.Fprintf(w, "%s: %v\n", source, err)
+            return exitInputMissing
+        }
+        defer f.Close()
+        r = f
+    }
+
+    code := 0
+    entries := 0
+    // Skip warnings would duplicate the per-line report
+    saved := skipWarn
+    skipWarn = func(string, ...interface{}) {}
+    defer func() { skipWarn = saved }()
+
+    r, err := maybeGunzip(r)
+    if err != nil {
+        fmt.Fprintf(w, "%s: %v\n", source, err)
+        return exitInputMissing
+    }
+    sc := bufio.NewScanner(r)
+    for n := 1; sc.Scan(); n++ {
+        line := cleanLine(sc.Text())
+        if line == "" {
+            continue
+        }
+        entries++
+        if err := parse(line); err != nil {
+            fmt.Fprintf(w, "%s:%d: invalid %q: %v\n", source, n, line, err)
+            code = exitInputInvalid
+        }
+    }
+    if err := sc.Err(); err != nil {
+        fmt.Fprintf(w, "%s: %v\n", source, err)
+        return exitInputMissing
+    }
+    if entries == 0 {
+        fmt.Fprintf(w, "%s: no entries\n", source)
+        return exitInputEmpty
+    }
+    return code
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "strconv"
+)
+
+// warmup reaches every test target directly, without a candidate proxy
+// in between, before any task is dispatched. A -test-urls or -real-target
+// target must answer the check's request with a status -accept-status
+// allows (after one redirect with -follow-redirect); a -test-ips target
+// must accept a connection. Otherwise every proxy would fail for the target's sake.
+func (s *Scanner) warmup() error {
+    for _, t := range s.HTTPTargets.targets {
+        if err := s.warmHTTP(t); err != nil {
+            return fmt.Errorf("test URL %s: %w", t.URL, err)
+        }
+    }
+    if s.RealTarget != nil {
+        if err := s.warmHTTP(s.RealTarget); err != nil {
+            return fmt.Errorf("real target %s: %w", s.RealTarget.URL, err)
+        }
+    }
+    for _, t := range s.SOCKS4Targets.targets {
+        address := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+        conn, err := s.dial(address)
+        if err != nil {
+            return fmt.Errorf("test IP %s: %w", address, err)
+        }
+        conn.Close()
+    }
+    return nil
+}
+
+// warmHTTP sends target the request a proxy would be asked to forward.
+// HTTP/1.1 servers accept the absolute-form request line directly.
+func (s *Scanner) warmHTTP(target *testTarget) error {
+    get := func(t *testTarget) (*httpResponse, error) {
+        conn, err := s.dial(net.JoinHostPort(t.Host, strconv.Itoa(t.Port)))
+        if err != nil {
+            return nil, err
+        }
+        defer conn.Close()
+        return s.roundTrip(conn, s.httpRequest(s.HTTPMethod, t))
+    }
+    resp, err := get(target)
+    if err != nil {
+        return err
+    }
+    if s.FollowRedirect && resp.Code >= 300 && resp.Code < 400 {
+        if next, ok := redirectTarget(target, resp.header("Location")); ok {
+            if resp, err = get(next); err != nil {
+                return fmt.Errorf("redirect to %s: %w", next.URL, err)
+            }
+        }
+    }
+    if !s.AcceptStatus.contains(resp.Code) {
+        return &statusError{resp.Code}
+    }
+    return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Worker states reported by -worker-stats
+const (
+    workerIdle int32 = iota
+    workerDialing
+    workerReading
+)
+
+var workerStateNames = [...]string{"idle", "dialing", "reading"}
+
+// workerStatus is what one scan worker is doing, updated atomically by the
+// worker and read by the -worker-stats reporter
+type workerStatus struct {
+    state   atomic.Int32
+    address atomic.Pointer[string]
+    since   atomic.Int64 // unix nanoseconds of the last state change
+}
+
+func (w *workerStatus) set(state int32, address string) {
+    w.address.Store(&address)
+    w.since.Store(time.Now().UnixNano())
+    w.state.Store(state)
+}
+
+// statusDialer marks its worker as dialing while a connection is opened
+// and as reading once it is up, until the worker goes idle again
+type statusDialer struct {
+    Dialer
+    status *workerStatus
+}
+
+func (d statusDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+    d.status.set(workerDialing, address)
+    conn, err := d.Dialer.DialTimeout(network, address, timeout)
+    d.status.set(workerReading, address)
+    return conn, err
+}
+
+func (d statusDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+    d.status.set(workerDialing, address)
+    conn, err := d.Dialer.DialContext(ctx, network, address)
+    d.status.set(workerReading, address)
+    return conn, err
+}
+
+// withStatus returns a copy of s whose dials update status
+func (s *Scanner) withStatus(status *workerStatus) *Scanner {
+    c := *s
+    c.Dialer = statusDialer{Dialer: s.Dialer, status: status}
+    return &c
+}
+
+// reportWorkers logs a tally of worker states every interval, followed by
+// one line per busy worker, slowest first
+func reportWorkers(statuses []workerStatus, interval time.Duration, logLevel string) {
+    for range time.Tick(interval) {
+        logPrint("info", logLevel, "%s", workerReport(statuses, time.Now()))
+    }
+}
+
+func workerReport(statuses []workerStatus, now time.Time) string {
+    type busy struct {
+        id      int
+        state   int32
+        address string
+        age     time.Duration
+    }
+    var counts [len(workerStateNames)]int
+    var working []busy
+    for i := range statuses {
+        w := &statuses[i]
+        state := w.state.Load()
+        counts[state]++
+        if state == workerIdle {
+            continue
+        }
+        address := ""
+        if a := w.address.Load(); a != nil {
+            address = *a
+        }
+        working = append(working, busy{i, state, address, now.Sub(time.Unix(0, w.since.Load()))})
+    }
+    sort.Slice(working, func(i, j int) bool { return working[i].age > working[j].age })
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "[*] Workers: %d idle, %d dialing, %d reading\n", counts[workerIdle], counts[workerDialing], counts[workerReading])
+    for _, w := range working {
+        fmt.Fprintf(&b, "    worker %-4d %-7s %s for %.1fs\n", w.id, workerStateNames[w.state], w.address, w.age.Seconds())
+    }
+    return b.String()
+}